@@ -1,7 +1,7 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -27,6 +27,7 @@ func NewArticleHandler(service *services.ArticleService, cfg *config.Config) *Ar
 // @Param article body services.CreateArticleInput true "Article info"
 // @Success 201 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/articles [post]
 func (h *ArticleHandler) CreateArticle(c *gin.Context) {
@@ -38,6 +39,14 @@ func (h *ArticleHandler) CreateArticle(c *gin.Context) {
 
 	result, err := h.Service.CreateArticle(req, h.Cfg)
 	if err != nil {
+		if errors.Is(err, services.ErrReservedProperty) || err == services.ErrInvalidPath {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == services.ErrPathExists {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -67,7 +76,7 @@ func (h *ArticleHandler) DeleteArticle(c *gin.Context) {
 
 	err = h.Service.DeleteArticle(id, h.Cfg)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == services.ErrArticleNotFound {
 			c.JSON(404, gin.H{"error": "Article not found"})
 		} else {
 			c.JSON(500, gin.H{"error": err.Error()})
@@ -87,6 +96,7 @@ func (h *ArticleHandler) DeleteArticle(c *gin.Context) {
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/articles/{id} [put]
 func (h *ArticleHandler) UpdateArticle(c *gin.Context) {
@@ -108,6 +118,10 @@ func (h *ArticleHandler) UpdateArticle(c *gin.Context) {
 	if err != nil {
 		if err == services.ErrArticleNotFound {
 			c.JSON(404, gin.H{"error": "Article not found"})
+		} else if errors.Is(err, services.ErrReservedProperty) || err == services.ErrInvalidPath {
+			c.JSON(400, gin.H{"error": err.Error()})
+		} else if err == services.ErrPathExists {
+			c.JSON(409, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(500, gin.H{"error": err.Error()})
 		}
@@ -116,3 +130,52 @@ func (h *ArticleHandler) UpdateArticle(c *gin.Context) {
 
 	c.JSON(200, gin.H{"message": "Article updated successfully"})
 }
+
+type moveArticleRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// MoveArticle godoc
+// @Summary Move or rename an article
+// @Description Renames the file, removes the folders left empty and rewrites the links of other articles to it
+// @Accept json
+// @Produce json
+// @Param id path int true "Article ID"
+// @Param body body moveArticleRequest true "New path"
+// @Success 200 {object} services.MoveResult
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/articles/{id}/move [post]
+func (h *ArticleHandler) MoveArticle(c *gin.Context) {
+	idStr := c.Param("id")
+	var id int64
+	_, err := fmt.Sscanf(idStr, "%d", &id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid article ID"})
+		return
+	}
+
+	var req moveArticleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	result, err := h.Service.MoveArticle(uint(id), req.Path, h.Cfg)
+	if err != nil {
+		if err == services.ErrArticleNotFound {
+			c.JSON(404, gin.H{"error": "Article not found"})
+		} else if err == services.ErrInvalidPath {
+			c.JSON(400, gin.H{"error": err.Error()})
+		} else if err == services.ErrPathExists {
+			c.JSON(409, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(500, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, result)
+}
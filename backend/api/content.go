@@ -97,13 +97,18 @@ func (h *ContentHandler) GetArticleContent(c *gin.Context) {
 	}
 
 	// Return combined data
+	properties := article.Properties
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"id":        article.ID,
-		"title":     article.Title,
-		"path":      article.Path,
-		"type":      article.Type,
-		"ref_count": article.RefCount,
-		"pin":       article.Pin,
-		"rawdata":   rawData,
+		"id":         article.ID,
+		"title":      article.Title,
+		"path":       article.Path,
+		"type":       article.Type,
+		"ref_count":  article.RefCount,
+		"pin":        article.Pin,
+		"rawdata":    rawData,
+		"properties": properties,
 	})
 }
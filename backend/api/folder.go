@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"pkms/backend/config"
+	"pkms/backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FolderHandler struct {
+	Service *services.ArticleService
+	Cfg     *config.Config
+}
+
+func NewFolderHandler(service *services.ArticleService, cfg *config.Config) *FolderHandler {
+	return &FolderHandler{Service: service, Cfg: cfg}
+}
+
+type createFolderRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+type moveFolderRequest struct {
+	Path    string `json:"path" binding:"required"`
+	NewPath string `json:"new_path" binding:"required"`
+}
+
+// CreateFolder godoc
+// @Summary Create a folder below the articles directory
+// @Accept json
+// @Produce json
+// @Param body body createFolderRequest true "Folder path"
+// @Success 201 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/folders [post]
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	var req createFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if err := h.Service.CreateFolder(req.Path, h.Cfg); err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Folder created successfully",
+		"path":    req.Path,
+	})
+}
+
+// MoveFolder godoc
+// @Summary Rename or move a folder
+// @Description Updates the paths of the articles inside and rewrites the links to them
+// @Accept json
+// @Produce json
+// @Param body body moveFolderRequest true "Folder path and new path"
+// @Success 200 {object} services.FolderMoveResult
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/folders [put]
+func (h *FolderHandler) MoveFolder(c *gin.Context) {
+	var req moveFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	result, err := h.Service.MoveFolder(req.Path, req.NewPath, h.Cfg)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteFolder godoc
+// @Summary Delete a folder
+// @Description Only an empty folder unless recursive is set, which deletes the articles inside too
+// @Produce json
+// @Param path query string true "Folder path"
+// @Param recursive query bool false "Delete the folder with its content"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/folders [delete]
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	recursive := false
+	if v := c.Query("recursive"); v != "" {
+		var err error
+		if recursive, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "recursive must be true or false"})
+			return
+		}
+	}
+
+	deleted, err := h.Service.DeleteFolder(path, recursive, h.Cfg)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Folder deleted successfully",
+		"path":             path,
+		"deleted_articles": deleted,
+	})
+}
+
+// Helper functions
+
+// folderError answers with the status of a folder service error.
+func folderError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrInvalidFolder:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case services.ErrFolderNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+	case services.ErrPathExists, services.ErrFolderNotEmpty:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pkms/backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number of related articles returned by default and at most.
+const (
+	defaultRelated = 10
+	maxRelated     = 50
+)
+
+type RelatedHandler struct {
+	Service *services.RelatedService
+}
+
+func NewRelatedHandler(service *services.RelatedService) *RelatedHandler {
+	return &RelatedHandler{Service: service}
+}
+
+// GetRelatedArticles godoc
+// @Summary Articles to read after an article
+// @Param id path int true "Article ID"
+// @Param limit query int false "Number of articles (10 by default, at most 50)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/articles/{id}/related [get]
+func (h *RelatedHandler) GetRelatedArticles(c *gin.Context) {
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid article ID"})
+		return
+	}
+	limit := defaultRelated
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		if n > maxRelated {
+			n = maxRelated
+		}
+		limit = n
+	}
+
+	related, err := h.Service.RelatedArticles(id, limit)
+	if err == services.ErrArticleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if related == nil {
+		related = []services.RelatedArticle{}
+	}
+	c.JSON(http.StatusOK, gin.H{"articles": related})
+}
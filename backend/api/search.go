@@ -1,22 +1,32 @@
 package api
 
 import (
-	"database/sql"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"pkms/backend/repository"
+	"pkms/backend/search"
 	"pkms/backend/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 type SearchHandler struct {
-	DB             *sql.DB
-	ContentService *services.ContentService
+	SearchService  *services.SearchService
+	SuggestService *services.SuggestService
 }
 
-func NewSearchHandler(db *sql.DB, contentService *services.ContentService) *SearchHandler {
-	return &SearchHandler{DB: db, ContentService: contentService}
+func NewSearchHandler(searchService *services.SearchService, suggestService *services.SuggestService) *SearchHandler {
+	return &SearchHandler{SearchService: searchService, SuggestService: suggestService}
 }
 
 type ArticleResult struct {
@@ -25,151 +35,284 @@ type ArticleResult struct {
 	Pin      bool     `json:"pin"`
 	RefCount int      `json:"ref_count"`
 	Sort     int      `json:"sort"`
+	Score    float64  `json:"score"`
 	Tags     []string `json:"tags"`
+	// Snippets show where a text query matched the body.
+	Snippets []search.Snippet `json:"snippets,omitempty"`
 }
 
-type searchArticle struct {
-	ID    uint
-	Title string
-	Path  string
+// TagCount is an item of allTags: a tag and the number of results
+// carrying it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
 func (h *SearchHandler) SearchArticles(c *gin.Context) {
-	query := c.Query("query")
 	path := c.Query("path")
 	tagStr := c.Query("tag")
+	req := services.SearchRequest{Options: services.QueryOptions{Fuzzy: true}}
+	var err error
+	switch mode := c.DefaultQuery("mode", modeText); mode {
+	case modeText:
+		// query=tag:food -tag:raw "exact phrase" title:cpu edited:>2024-01-01
+		if req.Query, err = search.ParseQuery(c.Query("query")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case modeRegex:
+		// mode=regex&query=TODO\(2024-\d\d\) (RE2 語法)
+		if req.Regex, err = compileRegex(c.Query("query")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be text or regex"})
+		return
+	}
+	// fuzzy=false 關閉容錯比對
+	if v := c.Query("fuzzy"); v != "" {
+		if req.Options.Fuzzy, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fuzzy must be true or false"})
+			return
+		}
+	}
 
-	// 1. 先用 path/tag filter 拿到 searchList
-	/*
-	 * SELECT a.id, a.title, a.path
-	 * FROM articles a
-	 * WHERE LOWER(a.path) LIKE LOWER('%food%')
-	 * AND a.id IN (
-	 *	  SELECT at.article_id
-	 *	  FROM article_tags at
-	 *	  JOIN tags t ON at.tag_id = t.id
-	 *	  WHERE t.name IN ('sweet')
-	 * )
-	 */
-	var args []interface{}
-	var where []string
-
-	if path != "" {
-		where = append(where, "LOWER(a.path) LIKE LOWER(?)")
-		args = append(args, "%"+path+"%")
-	}
-	if tagStr != "" {
-		tags := strings.Split(tagStr, ",")
-		placeholders := make([]string, len(tags))
-		for i, t := range tags {
-			placeholders[i] = "?"
-			args = append(args, t)
-		}
-		where = append(where, "a.id IN (SELECT at.article_id FROM article_tags at JOIN tags t ON at.tag_id = t.id WHERE t.name IN ("+strings.Join(placeholders, ",")+"))")
-	}
-
-	whereClause := ""
-	if len(where) > 0 {
-		whereClause = "WHERE " + strings.Join(where, " AND ")
-	}
-
-	querySQL := `SELECT a.id, a.title, a.path FROM articles a ` + whereClause
-	rows, err := h.DB.Query(querySQL, args...)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// sort=edit_date:desc&limit=20&cursor=... 排序與分頁
+	ranked := req.Regex != nil || (req.Query != nil && len(search.TextClauses(req.Query)) > 0)
+	if err := parsePage(c, ranked, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	var searchList []searchArticle
-	for rows.Next() {
-		var a searchArticle
-		if err := rows.Scan(&a.ID, &a.Title, &a.Path); err == nil {
-			searchList = append(searchList, a)
-		}
-	}
-
-	// 2. maintain resultList: [{id, sort}]
-	type resultItem struct {
-		ID   uint
-		Sort int
-	}
-	// c.JSON(http.StatusOK, gin.H{
-	// 	"searchList": searchList,
-	// })
-	var resultList []resultItem
-
-	if query != "" {
-		for i := 0; i < len(searchList); i++ {
-			if strings.Contains(searchList[i].Title, query) {
-				// 3. 先做 title string match Sort:1
-				resultList = append(resultList, resultItem{searchList[i].ID, 1})
-			} else {
-				// 4. 剩下的做 content match Sort:2
-				content, err := h.ContentService.GetContent(searchList[i].Path)
-				if err == nil && strings.Contains(content, query) {
-					resultList = append(resultList, resultItem{searchList[i].ID, 2})
-				}
+
+	// 1. path/tag filter
+	// tag=food,sweet&tag_mode=all&exclude_tag=raw
+	filter := repository.ArticleFilter{Path: path, Tags: splitList(tagStr), ExcludeTags: splitList(c.Query("exclude_tag"))}
+	switch mode := repository.TagMode(c.DefaultQuery("tag_mode", string(repository.TagsAny))); mode {
+	case repository.TagsAny, repository.TagsAll:
+		filter.TagMode = mode
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag_mode must be all or any"})
+		return
+	}
+	// edited_after=7d&created_before=2024-01-01
+	now := time.Now()
+	for _, d := range []struct {
+		param string
+		at    *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"edited_after", &filter.EditedAfter},
+		{"edited_before", &filter.EditedBefore},
+	} {
+		if v := c.Query(d.param); v != "" {
+			from, _, err := search.ParseDate(v, now)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s %v", d.param, err)})
+				return
 			}
+			*d.at = from
 		}
-	} else {
-		for i := 0; i < len(searchList); i++ {
-			resultList = append(resultList, resultItem{ID: searchList[i].ID, Sort: 0})
+	}
+	// prop=status=draft&prop=priority>2; every operator but a bare name
+	// needs a single value, so prop=status!=draft skips notes without status
+	for _, expr := range c.QueryArray("prop") {
+		propFilter, err := repository.ParsePropertyFilter(expr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		filter.Properties = append(filter.Properties, propFilter)
 	}
+	req.Filter = filter
 
-	// 5. 根據 resultList id 查 DB
-	if len(resultList) == 0 {
-		c.JSON(http.StatusOK, []ArticleResult{})
+	// 2. 搜尋
+	result, err := h.SearchService.Search(c.Request.Context(), req)
+	if errors.Is(err, repository.ErrInvalidSort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "search timed out"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 3. 組合最終 Result
+	results := make([]ArticleResult, len(result.Hits))
+	for i, hit := range result.Hits {
+		results[i] = ArticleResult{
+			ID:       hit.Article.ID,
+			Title:    hit.Article.Title,
+			Pin:      hit.Article.Pin,
+			RefCount: hit.Article.RefCount,
+			Sort:     hit.Sort,
+			Score:    math.Round(hit.Score*1000) / 1000,
+			Tags:     hit.Tags,
+			Snippets: hit.Snippets,
+		}
+	}
+	allTags := make([]TagCount, len(result.Facets.Tags))
+	for i, fc := range result.Facets.Tags {
+		allTags[i] = TagCount{Name: fc.Value, Count: fc.Count}
+	}
+	response := gin.H{
+		"articles":    results,
+		"allTags":     allTags,
+		"total":       result.Total,
+		"facets":      result.Facets,
+		"next_cursor": nil,
+		"truncated":   result.Truncated,
+	}
+	if result.Next != nil {
+		response["next_cursor"] = encodeCursor(result.Next)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Suggest completes the search bar: query=gar&kind=tag,title&limit=10
+func (h *SearchHandler) Suggest(c *gin.Context) {
+	limit := defaultSuggestions
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		if n > maxSuggestions {
+			n = maxSuggestions
+		}
+		limit = n
 	}
-	idSort := map[uint]int{}
-	idList := make([]string, 0, len(resultList))
-	for _, r := range resultList {
-		idSort[r.ID] = r.Sort
-		idList = append(idList, "?")
-		args = append(args, r.ID)
+	kinds := splitList(c.Query("kind"))
+	for _, k := range kinds {
+		switch k {
+		case search.SuggestTitle, search.SuggestTag, search.SuggestFolder, search.SuggestTerm:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown kind %q", k)})
+			return
+		}
 	}
 
-	// 查詢最終 Result
-	finalSQL := `SELECT id, title, pin, ref_count FROM articles WHERE id IN (` + strings.Join(idList, ",") + `)`
-	finalRows, err := h.DB.Query(finalSQL, args[len(args)-len(resultList):]...)
+	suggestions, err := h.SuggestService.Suggest(c.Query("query"), kinds, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer finalRows.Close()
-
-	var results []ArticleResult
-	allTagsSet := map[string]struct{}{}
-	for finalRows.Next() {
-		var a ArticleResult
-		if err := finalRows.Scan(&a.ID, &a.Title, &a.Pin, &a.RefCount); err == nil {
-			a.Sort = idSort[a.ID]
-			// 查詢 tags
-			tagRows, err := h.DB.Query(`SELECT t.name FROM tags t JOIN article_tags at ON t.id = at.tag_id WHERE at.article_id = ?`, a.ID)
-			if err == nil {
-				var tagNames []string
-				for tagRows.Next() {
-					var tag string
-					if err := tagRows.Scan(&tag); err == nil {
-						tagNames = append(tagNames, tag)
-						allTagsSet[tag] = struct{}{}
-					}
-				}
-				tagRows.Close()
-				a.Tags = tagNames
-			}
-			results = append(results, a)
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+// Helper functions
+
+// splitList splits a comma separated parameter, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// maxPageSize caps the limit parameter.
+const maxPageSize = 500
+
+// Number of suggestions returned by default and at most.
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 50
+)
+
+// Search modes: the query language or a regular expression.
+const (
+	modeText  = "text"
+	modeRegex = "regex"
+)
+
+// maxRegexLength caps the length of a regex pattern.
+const maxRegexLength = 1000
+
+// compileRegex compiles a regex mode pattern; an empty one lists the
+// articles like an empty query.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("regex longer than %d bytes", maxRegexLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return re, nil
+}
+
+func encodeCursor(cur *services.SearchCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*services.SearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cur services.SearchCursor
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cur, nil
+}
+
+// parsePage reads the sort, limit and cursor parameters into req. Text
+// queries sort by relevance unless told otherwise, listings by id.
+func parsePage(c *gin.Context, ranked bool, req *services.SearchRequest) error {
+	switch v := c.Query("sort"); v {
+	case "", services.SortRelevance:
+		req.Relevance = ranked
+	default:
+		order, err := repository.ParseArticleSort(v)
+		if err != nil {
+			return err
+		}
+		req.Sort = order
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return errors.New("limit must be a positive number")
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
 		}
+		req.Limit = limit
 	}
-	// collect allTags
-	allTags := make([]string, 0, len(allTagsSet))
-	for tag := range allTagsSet {
-		allTags = append(allTags, tag)
+
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil {
+			return err
+		}
+		want := req.Sort.String()
+		if req.Relevance {
+			want = services.SortRelevance
+		}
+		if cur.Sort != want {
+			return fmt.Errorf("cursor belongs to sort %q, not %q", cur.Sort, want)
+		}
+		if req.Relevance {
+			if cur.Offset < 0 {
+				return errors.New("invalid cursor")
+			}
+			req.Offset = cur.Offset
+		} else {
+			req.After = &repository.ArticleCursor{ID: cur.ID, Value: cur.Value}
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"articles": results,
-		"allTags":  allTags,
-	})
+	return nil
 }
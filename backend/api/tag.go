@@ -1,25 +1,25 @@
 package api
 
 import (
-	"database/sql"
 	"net/http"
 
+	"pkms/backend/repository"
 	"pkms/backend/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 type TagHandler struct {
-	DB *sql.DB
+	Repo repository.Repository
 }
 
-func NewTagHandler(db *sql.DB) *TagHandler {
-	return &TagHandler{DB: db}
+func NewTagHandler(repo repository.Repository) *TagHandler {
+	return &TagHandler{Repo: repo}
 }
 
 func (h *TagHandler) GetTags(c *gin.Context) {
 	query := c.Query("query")
-	tags, err := services.GetTags(h.DB, query)
+	tags, err := services.GetTags(h.Repo, query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1,63 +1,105 @@
 package commands
 
 import (
-	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"pkms/backend/config"
-
-	_ "github.com/go-sql-driver/mysql"
+	"pkms/backend/repository"
+	"pkms/backend/services"
 )
 
-// Migrate runs database migrations
+// Migrate applies, rolls back or lists the versioned migrations in
+// db/migrations/<driver>.
+//
+//	migrate [up]       apply every pending migration
+//	migrate down [N]   roll back the last N migrations (default 1)
+//	migrate status     list migrations and whether they are applied
 func Migrate(cfg *config.Config) {
 	flagSet := flag.NewFlagSet("migrate", flag.ExitOnError)
-	initFlag := flagSet.String("init", "init", "SQL file name (without .sql extension) in db/ directory")
 	flagSet.Parse(os.Args[2:])
 
-	sqlFile := fmt.Sprintf("db/%s.sql", *initFlag)
-	fmt.Printf("Running migration using %s...\n", sqlFile)
+	action := "up"
+	if flagSet.NArg() > 0 {
+		action = flagSet.Arg(0)
+	}
 
-	// 1. 讀取 SQL 檔案
-	content, err := ioutil.ReadFile(sqlFile)
+	// 1. 連接資料庫
+	repo, err := repository.Open(cfg)
 	if err != nil {
-		log.Fatal("Failed to read SQL file:", err)
+		log.Fatal("Failed to connect to database:", err)
 	}
+	defer repo.Close()
 
-	// 2. 連接資料庫
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-	db, err := sql.Open("mysql", dsn)
+	migrator, err := repository.NewMigrator(repo)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to load migrations:", err)
 	}
-	defer db.Close()
 
-	// 3. 分割並執行每條 SQL
-	statements := splitSQL(string(content))
-	for i, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
+	// 2. 執行對應動作
+	switch action {
+	case "up":
+		fmt.Println("Applying pending migrations...")
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("  ↑ %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is already up to date.")
+		} else {
+			fmt.Printf("Applied %d migration(s).\n", len(applied))
 		}
-		_, err := db.Exec(stmt)
+	case "down":
+		n := 1
+		if flagSet.NArg() > 1 {
+			n, err = strconv.Atoi(flagSet.Arg(1))
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid migration count: %s", flagSet.Arg(1))
+			}
+		}
+		fmt.Printf("Rolling back %d migration(s)...\n", n)
+		reverted, err := migrator.Down(n)
+		for _, m := range reverted {
+			fmt.Printf("  ↓ %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Rollback failed: ", err)
+		}
+		fmt.Printf("Rolled back %d migration(s).\n", len(reverted))
+	case "status":
+		statuses, err := migrator.Status()
 		if err != nil {
-			log.Printf("Failed to execute statement %d: %v\nSQL: %s\n", i+1, err, stmt)
+			log.Fatal("Failed to read migration status: ", err)
 		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if st.Modified {
+				state += " (MODIFIED since applied)"
+			}
+			fmt.Printf("  %04d_%-30s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		log.Fatalf("Unknown migrate action: %s (use up, down N or status)", action)
 	}
-
-	fmt.Println("Database migration completed successfully!")
 }
 
-// Backup creates a database backup (Under Developed)
+// Backup writes a tar.gz archive with a portable dump of the database and
+// every file under the articles root
 func Backup(cfg *config.Config) {
 	flagSet := flag.NewFlagSet("backup", flag.ExitOnError)
 	outputFlag := flagSet.String("output", "", "Output file for backup")
@@ -66,10 +108,10 @@ func Backup(cfg *config.Config) {
 	if *outputFlag == "" {
 		// Generate default filename with timestamp
 		timestamp := time.Now().Format("20060102_150405")
-		*outputFlag = fmt.Sprintf("backup_%s.sql", timestamp)
+		*outputFlag = fmt.Sprintf("backup_%s.tar.gz", timestamp)
 	}
 
-	fmt.Printf("Creating database backup to %s...\n", *outputFlag)
+	fmt.Printf("Creating backup to %s...\n", *outputFlag)
 
 	// Create backup directory if it doesn't exist
 	backupDir := filepath.Dir(*outputFlag)
@@ -79,412 +121,377 @@ func Backup(cfg *config.Config) {
 		}
 	}
 
-	// Use mysqldump command line tool for backup
-	cmd := exec.Command("mysqldump",
-		"-h", cfg.DBHost,
-		"-P", fmt.Sprintf("%d", cfg.DBPort),
-		"-u", cfg.DBUser,
-		"-p"+cfg.DBPassword,
-		"--single-transaction",
-		"--routines",
-		"--triggers",
-		cfg.DBName)
+	repo, err := repository.Open(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer repo.Close()
 
 	outputFile, err := os.Create(*outputFlag)
 	if err != nil {
 		log.Fatal("Failed to create output file:", err)
 	}
-	defer outputFile.Close()
 
-	cmd.Stdout = outputFile
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	manifest, err := services.NewBackupService(repo, cfg).Create(outputFile)
+	if err == nil {
+		err = outputFile.Close()
+	}
+	if err != nil {
+		outputFile.Close()
+		os.Remove(*outputFlag)
 		log.Fatal("Failed to create backup:", err)
 	}
 
-	fmt.Printf("Database backup created successfully: %s\n", *outputFlag)
+	fmt.Printf("  articles:           %d\n", manifest.Counts.Articles)
+	fmt.Printf("  tags:               %d\n", manifest.Counts.Tags)
+	fmt.Printf("  article_tags:       %d\n", manifest.Counts.ArticleTags)
+	fmt.Printf("  article_properties: %d\n", manifest.Counts.Properties)
+	fmt.Printf("  files:              %d (%d bytes)\n", manifest.Counts.Files, manifest.Counts.FileBytes)
+	fmt.Printf("Backup created successfully: %s\n", *outputFlag)
 }
 
-// Fix fixes common database issues
+// Fix reconciles the articles directory into the database: untracked
+// markdown files are inserted, tracked files whose frontmatter drifted are
+// updated and rows whose file vanished are deleted. It also cleans orphaned
+// and duplicated rows.
 func Fix(cfg *config.Config) {
 	flagSet := flag.NewFlagSet("fix", flag.ExitOnError)
-	checkOnly := flagSet.Bool("check-only", false, "Only check for issues, don't fix them")
+	dryRun := flagSet.Bool("dry-run", false, "Only show the plan, don't change anything")
+	checkOnly := flagSet.Bool("check-only", false, "Alias of --dry-run")
+	jsonOutput := flagSet.Bool("json", false, "Print the plan as JSON")
 	flagSet.Parse(os.Args[2:])
+	if *checkOnly {
+		*dryRun = true
+	}
 
-	fmt.Println("Checking database for common issues...")
-
-	db, err := sql.Open("mysql", getDSN(cfg))
+	repo, err := repository.Open(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	defer db.Close()
+	defer repo.Close()
 
-	// 1. 讀取 articles 表
-	rows, err := db.Query("SELECT id, path FROM articles")
+	// 1. 比對 articles/ 與 DB
+	syncService := services.NewSyncService(repo, cfg)
+	plan, err := syncService.Plan()
 	if err != nil {
-		log.Fatal("Failed to query articles:", err)
+		log.Fatal("Failed to compare articles with database:", err)
 	}
-	defer rows.Close()
 
-	type Article struct {
-		ID   int64
-		Path string
-	}
-	var curArticles []Article
-	for rows.Next() {
-		var a Article
-		if err := rows.Scan(&a.ID, &a.Path); err != nil {
-			log.Printf("Error scanning article: %v\n", err)
-			continue
-		}
-		curArticles = append(curArticles, a)
+	// 2. 完整性檢查
+	integrity, err := repo.CheckIntegrity()
+	if err != nil {
+		log.Fatal("Failed to check database integrity:", err)
 	}
 
-	// 2. 檢查文件是否存在
-	var delArticles []int64
-	for _, a := range curArticles {
-		articlePath := filepath.Join("articles", a.Path)
-		if _, err := os.Stat(articlePath); os.IsNotExist(err) {
-			delArticles = append(delArticles, a.ID)
-			fmt.Printf("Article file not found: %s (id=%d)\n", articlePath, a.ID)
-		}
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(struct {
+			Plan      *services.SyncPlan          `json:"plan"`
+			Integrity *repository.IntegrityReport `json:"integrity"`
+		}{plan, integrity}, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("Checking %s against the database...\n", cfg.SearchPath)
+		printPlan(plan)
+		printIntegrity(integrity)
 	}
 
-	// 3. 刪除 DB 中 "現實中 不存在的 article"
-	deleteArticleByID(db, delArticles, *checkOnly)
-
-	// 4. Recursive ./articles
-	var foundFiles []string
-	err = filepath.Walk("articles", func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if *dryRun {
+		if !*jsonOutput {
+			fmt.Println("✅ Database check completed! (dry run, nothing was changed)")
 		}
-		if !info.IsDir() {
-			relPath, err := filepath.Rel("articles", path)
-			if err != nil {
-				return err
-			}
-			foundFiles = append(foundFiles, relPath)
-		}
-		return nil
-	})
-	if err != nil && err.Error() != "stop" {
-		fmt.Printf("Error walking articles directory: %v\n", err)
-	}
-
-	// 用 map 方便查找 DB 中已存在的 path
-	curPathMap := make(map[string]struct{})
-	for _, a := range curArticles {
-		curPathMap[a.Path] = struct{}{}
+		return
 	}
 
-	// 選擇更新 or 新增
-	fmt.Printf("Found %d files in filesystem\n", len(foundFiles))
-	fmt.Printf("Found %d paths in database\n", len(curPathMap))
-
-	// Debug: 顯示資料庫中的路徑
-	fmt.Println("Database paths:")
-	for path := range curPathMap {
-		fmt.Printf("  DB: '%s'\n", path)
+	// 3. 套用
+	failed := syncService.Apply(plan)
+	for _, f := range failed {
+		fmt.Printf("⚠️  Failed to apply %s: %s\n", f.Path, f.Err)
 	}
-
-	// Debug: 顯示文件系統中的路徑
-	fmt.Println("Filesystem paths:")
-	for _, f := range foundFiles {
-		fmt.Printf("  FS: '%s'\n", f)
+	repaired, err := repo.RepairIntegrity()
+	if err != nil {
+		log.Fatal("Failed to repair database integrity:", err)
 	}
-
-	for i, f := range foundFiles {
-		// print(title, create_date, edit_date)
-		if _, exists := curPathMap[f]; exists {
-			fmt.Printf("  %d. update %s\n", i+1, f)
-		} else {
-			fmt.Printf("  %d. insert %s (NOT IN DB)\n", i+1, f)
-			// 檢查是否有相似路徑（忽略大小寫）
-			for dbPath := range curPathMap {
-				if strings.ToLower(f) == strings.ToLower(dbPath) {
-					fmt.Printf("     -> Similar path in DB: %s\n", dbPath)
-				}
-			}
+	for _, table := range sortedTables(repaired.Orphans) {
+		if n := repaired.Orphans[table]; n > 0 {
+			fmt.Printf("Cleaned %d orphaned %s records\n", n, table)
 		}
 	}
+	if repaired.DuplicateTags > 0 {
+		fmt.Printf("Cleaned %d duplicate tags\n", repaired.DuplicateTags)
+	}
 
-	// Check for orphaned records in TABLE article_tags
-	checkOrphanedRecords(db, "article_tags", *checkOnly)
-
-	// Check for orphaned records in TABLE search_index
-	checkOrphanedRecords(db, "search_index", *checkOnly)
-
-	// Check for duplicate entries in Table tags
-	checkDuplicateEntries(db, *checkOnly)
-
-	if *checkOnly {
-		fmt.Println("✅ Database check completed!")
-	} else {
-		fmt.Println("✅ Database fixes completed!")
+	fmt.Printf("Applied %d of %d changes\n", len(plan.Changes)-len(failed), len(plan.Changes))
+	if len(failed) > 0 {
+		os.Exit(1)
 	}
+	fmt.Println("✅ Database fixes completed!")
 }
 
 // Status checks database status
 func Status(cfg *config.Config) {
 	fmt.Println("Checking database status...")
 
-	db, err := sql.Open("mysql", getDSN(cfg))
+	repo, err := repository.Open(cfg)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fmt.Println("Database connection failed:", err)
+		return
 	}
-	defer db.Close()
+	defer repo.Close()
 
-	// Test connection
-	if err := db.Ping(); err != nil {
+	schema, err := repo.Schema()
+	if err != nil {
 		fmt.Println("Database connection failed:", err)
 		return
 	}
 
 	fmt.Println("Database connection successful")
-
-	// Get database info
-	var version string
-	err = db.QueryRow("SELECT VERSION()").Scan(&version)
-	if err != nil {
-		log.Printf("Warning: Failed to get database version: %v\n", err)
-	} else {
-		fmt.Printf("Database version: %s\n", version)
-	}
+	fmt.Printf("Database version: %s %s\n", schema.Driver, schema.Version)
 
 	// Get table info (name and columns)
-	tables := []string{"articles", "tags", "article_tags", "search_index"}
-	for _, table := range tables {
-		fmt.Printf("\nTable: %s\n", table)
-
-		// Get column information
-		rows, err := db.Query(fmt.Sprintf("DESCRIBE %s", table))
-		if err != nil {
-			fmt.Printf("Error getting columns: %v\n", err)
+	for _, table := range schema.Tables {
+		fmt.Printf("\nTable: %s\n", table.Name)
+		if table.Missing {
+			fmt.Println("    (missing, run migrate)")
 			continue
 		}
-		defer rows.Close()
 
 		fmt.Printf("Columns:\n")
-		for rows.Next() {
-			var field, typ, null, key, defaultVal, extra sql.NullString
-			err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra)
-			if err != nil {
-				fmt.Printf("Error reading column info: %v\n", err)
-				continue
-			}
-
+		for _, column := range table.Columns {
 			// Format the column info
-			columnInfo := fmt.Sprintf("    - %s (%s)", field.String, typ.String)
-			if key.String == "PRI" {
+			columnInfo := fmt.Sprintf("    - %s (%s)", column.Name, column.Type)
+			if column.PrimaryKey {
 				columnInfo += " [PRIMARY KEY]"
 			}
-			if null.String == "NO" {
+			if column.NotNull {
 				columnInfo += " [NOT NULL]"
 			}
-			if defaultVal.Valid {
-				columnInfo += fmt.Sprintf(" [DEFAULT: %s]", defaultVal.String)
+			if column.Default != nil {
+				columnInfo += fmt.Sprintf(" [DEFAULT: %s]", *column.Default)
 			}
 			fmt.Println(columnInfo)
 		}
-
-		if err = rows.Err(); err != nil {
-			fmt.Printf("Error iterating columns: %v\n", err)
-		}
 	}
 }
 
-// Create drops and recreates the entire database
+// Restore loads a backup archive created by Backup into the database and
+// the articles directory
 func Restore(cfg *config.Config) {
-	flagSet := flag.NewFlagSet("create", flag.ExitOnError)
-	forceFlag := flagSet.Bool("force", false, "Force recreation without confirmation")
-	migrateFile := flagSet.String("migrate-file", "init", "SQL file name (without .sql extension) to use for migration")
+	flagSet := flag.NewFlagSet("restore", flag.ExitOnError)
+	fromFlag := flagSet.String("from", "", "Backup archive (.tar.gz) to restore")
+	forceFlag := flagSet.Bool("force", false, "Wipe a non-empty database and articles directory before restoring")
+	dryRun := flagSet.Bool("dry-run", false, "Only verify the archive and show what would change")
 	flagSet.Parse(os.Args[2:])
 
-	fmt.Printf("Database: %s\n", cfg.DBName)
-	fmt.Printf("Migration file: db/%s.sql\n", *migrateFile)
+	if *fromFlag == "" {
+		log.Fatal("Missing --from=<backup archive>")
+	}
+
+	fmt.Printf("Restoring %s into %s database and %s...\n", *fromFlag, cfg.DBDriver, cfg.SearchPath)
 
-	if *forceFlag {
-		fmt.Println("WARNING: This will DELETE the entire database and recreate it!")
+	if *forceFlag && !*dryRun {
+		fmt.Println("WARNING: This will DELETE every article, tag and article file before restoring!")
 		fmt.Print("Are you sure you want to continue? (yes/no): ")
 		var response string
 		fmt.Scanln(&response)
 		if response != "yes" && response != "y" {
-			fmt.Println("Cancelled Drop Database.")
-		} else {
-			// Drop and create database
-			dropCreateDB(cfg)
+			fmt.Println("Cancelled restore.")
+			return
 		}
 	}
 
-	// Now run migration to create tables and initial data
-	fmt.Printf("Running migration using db/%s.sql...\n", *migrateFile)
-
-	// Temporarily modify os.Args to pass the migrate-file parameter to Migrate
-	originalArgs := os.Args
-	os.Args = append([]string{os.Args[0], "migrate", "--init=" + *migrateFile})
-	defer func() { os.Args = originalArgs }()
+	repo, err := repository.Open(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer repo.Close()
 
-	Migrate(cfg)
+	// 1. 確保 schema 存在
+	migrator, err := repository.NewMigrator(repo)
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		log.Fatal("Migration failed: ", err)
+	}
 
-	fmt.Println("Database creation completed successfully!")
-}
+	// 2. 驗證並還原
+	archive, err := os.Open(*fromFlag)
+	if err != nil {
+		log.Fatal("Failed to open backup:", err)
+	}
+	defer archive.Close()
 
-// Helper functions
+	report, err := services.NewBackupService(repo, cfg).Restore(archive, services.RestoreOptions{
+		Force:  *forceFlag,
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrTargetNotEmpty) {
+			log.Fatal(err, " (use --force to wipe it)")
+		}
+		log.Fatal("Restore failed: ", err)
+	}
 
-// dropCreateDB drops and recreates the database
-func dropCreateDB(cfg *config.Config) {
-	fmt.Println("Dropping database...")
+	// 3. 差異摘要
+	fmt.Printf("Backup created at %s\n", report.Manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Println("              added  removed  changed  unchanged")
+	printDiff("articles", report.Articles)
+	printDiff("tags", report.Tags)
+	printDiff("files", report.Files)
 
-	// Connect without specifying database
-	dsnWithoutDB := fmt.Sprintf("%s:%s@tcp(%s:%d)/?parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort)
+	if *dryRun {
+		fmt.Println("✅ Backup verified (dry run, nothing was restored)")
+		return
+	}
 
-	db, err := sql.Open("mysql", dsnWithoutDB)
+	// 4. 重建搜尋索引 (not part of the archive)
+	indexReport, err := services.NewIndexService(repo, cfg).Reindex(true)
 	if err != nil {
-		log.Fatal("Failed to connect to database server:", err)
+		log.Fatal("Failed to rebuild the search index: ", err)
 	}
-	defer db.Close()
+	fmt.Printf("Indexed %d articles\n", indexReport.Indexed)
+	fmt.Println("✅ Restore completed and verified!")
+}
 
-	// Drop database if exists
-	_, err = db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", cfg.DBName))
+// Reindex rebuilds the full-text search index from the article files. By
+// default only articles whose file changed since it was indexed are
+// processed; --force rebuilds every entry.
+func Reindex(cfg *config.Config) {
+	flagSet := flag.NewFlagSet("reindex", flag.ExitOnError)
+	force := flagSet.Bool("force", false, "Rebuild every article, not only changed files")
+	flagSet.Parse(os.Args[2:])
+
+	repo, err := repository.Open(cfg)
 	if err != nil {
-		log.Fatal("Failed to drop database:", err)
+		log.Fatal("Failed to connect to database:", err)
 	}
-	fmt.Printf("Dropped database: %s\n", cfg.DBName)
+	defer repo.Close()
 
-	// Create new database
-	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci", cfg.DBName))
+	fmt.Println("Indexing articles...")
+	start := time.Now()
+	report, err := services.NewIndexService(repo, cfg).Reindex(*force)
 	if err != nil {
-		log.Fatal("Failed to create database:", err)
+		log.Fatal("Failed to index articles:", err)
 	}
-	fmt.Printf("Created database: %s\n", cfg.DBName)
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
+	for _, e := range report.Errors {
+		fmt.Printf("⚠️  %s: %s\n", e.Path, e.Err)
 	}
-	return b
+	fmt.Printf("Indexed %d articles, %d unchanged (%s)\n", report.Indexed, report.Unchanged, time.Since(start).Round(time.Millisecond))
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("✅ Search index is up to date!")
 }
 
-func getDSN(cfg *config.Config) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-}
+// Move renames an article file, given by path or id, and rewrites the
+// links of the other articles to it.
+func Move(cfg *config.Config) {
+	flagSet := flag.NewFlagSet("move", flag.ExitOnError)
+	fromFlag := flagSet.String("from", "", "Path (relative to SEARCH_PATH) or id of the article to move")
+	toFlag := flagSet.String("to", "", "New path of the article, relative to SEARCH_PATH")
+	flagSet.Parse(os.Args[2:])
 
-func splitSQL(sql string) []string {
-	var statements []string
-	var current strings.Builder
-	inSingleQuote := false
-	inDoubleQuote := false
+	if *fromFlag == "" || *toFlag == "" {
+		log.Fatal("Missing --from=<path or id> or --to=<new path>")
+	}
 
-	for _, r := range sql {
-		ch := string(r)
-		if ch == "'" && !inDoubleQuote {
-			inSingleQuote = !inSingleQuote
-		}
-		if ch == "\"" && !inSingleQuote {
-			inDoubleQuote = !inDoubleQuote
+	repo, err := repository.Open(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer repo.Close()
+
+	// 1. 找出文章
+	id, err := strconv.ParseUint(*fromFlag, 10, 64)
+	if err != nil {
+		articles, err := repo.FindArticles(repository.ArticleFilter{Path: *fromFlag})
+		if err != nil {
+			log.Fatal("Failed to find article:", err)
 		}
-		current.WriteRune(r)
-		if ch == ";" && !inSingleQuote && !inDoubleQuote {
-			stmt := strings.TrimSpace(current.String())
-			if stmt != "" {
-				statements = append(statements, stmt)
+		for _, a := range articles {
+			if a.Path == filepath.ToSlash(*fromFlag) {
+				id = uint64(a.ID)
 			}
-			current.Reset()
+		}
+		if id == 0 {
+			log.Fatalf("No article at %s", *fromFlag)
 		}
 	}
-	// Add any remaining statement
-	stmt := strings.TrimSpace(current.String())
-	if stmt != "" {
-		statements = append(statements, stmt)
-	}
-	return statements
-}
 
-func deleteArticleByID(db *sql.DB, IDArray []int64, checkOnly bool) {
-	if len(IDArray) > 0 {
-		if checkOnly {
-			fmt.Printf("Found %d articles in DB but missing files. (No deletion in check-only mode)\n", len(IDArray))
-		} else {
-			fmt.Printf("Deleting %d articles from DB that have no corresponding file...\n", len(IDArray))
-			for _, id := range IDArray {
-				_, err := db.Exec("DELETE FROM articles WHERE id = ?", id)
-				if err != nil {
-					fmt.Printf("Failed to delete article id=%d: %v\n", id, err)
-				} else {
-					fmt.Printf("Deleted article id=%d\n", id)
-				}
-			}
-		}
-	} else {
-		fmt.Println("All articles have corresponding files.")
+	// 2. 搬移並改寫連結
+	result, err := services.NewArticleService(repo).MoveArticle(uint(id), filepath.ToSlash(*toFlag), cfg)
+	if err != nil {
+		log.Fatal("Move failed: ", err)
+	}
+	fmt.Printf("Moved %s -> %s\n", result.From, result.To)
+	for _, p := range result.Updated {
+		fmt.Printf("  ✎ %s\n", p)
 	}
+	fmt.Printf("✅ Rewrote links in %d article(s)\n", len(result.Updated))
 }
 
-func checkOrphanedRecords(db *sql.DB, tableName string, checkOnly bool) {
-	var count int
-	query := fmt.Sprintf(`
-		SELECT COUNT(*) FROM %s t
-		LEFT JOIN articles a ON t.article_id = a.id
-		WHERE a.id IS NULL
-	`, tableName)
+// Helper functions
 
-	err := db.QueryRow(query).Scan(&count)
-	if err != nil {
-		fmt.Printf("⚠️  Error checking orphaned %s: %v\n", tableName, err)
-		return
+func printDiff(name string, d services.DiffCounts) {
+	fmt.Printf("  %-10s %7d  %7d  %7d  %9d\n", name, d.Added, d.Removed, d.Changed, d.Unchanged)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
 	}
+	return b
+}
 
-	if count > 0 {
-		fmt.Printf("Found %d orphaned %s records\n", count, tableName)
-		if !checkOnly {
-			delQuery := fmt.Sprintf("DELETE FROM %s WHERE article_id NOT IN (SELECT id FROM articles)", tableName)
-			_, err := db.Exec(delQuery)
-			if err != nil {
-				fmt.Printf("Failed to clean orphaned %s: %v\n", tableName, err)
-			} else {
-				fmt.Printf("Cleaned %d orphaned %s records\n", count, tableName)
+func printPlan(plan *services.SyncPlan) {
+	counts := map[services.SyncAction]int{}
+	for _, c := range plan.Changes {
+		counts[c.Action]++
+	}
+	fmt.Printf("Plan: %d insert, %d update, %d delete, %d unchanged\n",
+		counts[services.SyncInsert], counts[services.SyncUpdate], counts[services.SyncDelete], plan.Unchanged)
+
+	for _, c := range plan.Changes {
+		switch c.Action {
+		case services.SyncInsert:
+			fmt.Printf("  + insert %s\n", c.Path)
+			fmt.Printf("      title=%q type=%q tags=[%s] create_date=%s edit_date=%s\n",
+				c.Article.Title, c.Article.Type, strings.Join(c.Tags, ", "),
+				c.Article.CreateDate.Format("2006-01-02"), c.Article.EditDate.Format("2006-01-02"))
+		case services.SyncUpdate:
+			fmt.Printf("  ~ update %s (id=%d)\n", c.Path, c.ID)
+			for _, fc := range c.Changes {
+				fmt.Printf("      %s: %q -> %q\n", fc.Field, fc.From, fc.To)
 			}
+		case services.SyncDelete:
+			fmt.Printf("  - delete %s (id=%d, file not found)\n", c.Path, c.ID)
 		}
-	} else {
-		fmt.Printf("No orphaned %s records found\n", tableName)
 	}
-}
-
-func checkDuplicateEntries(db *sql.DB, checkOnly bool) {
-	// Check for duplicate tags
-	var count int
-	err := db.QueryRow(`
-		SELECT COUNT(*) FROM (
-			SELECT name, COUNT(*) as cnt FROM tags GROUP BY name HAVING cnt > 1
-		) as duplicates
-	`).Scan(&count)
-
-	if err != nil {
-		fmt.Printf("⚠️  Error checking duplicate tags: %v\n", err)
-		return
+	for _, e := range plan.Errors {
+		fmt.Printf("  ! skip   %s: %s\n", e.Path, e.Err)
 	}
+}
 
-	if count > 0 {
-		fmt.Printf("⚠️  Found %d duplicate tag names\n", count)
-		if !checkOnly {
-			// Keep the first occurrence of each tag name
-			_, err := db.Exec(`
-				DELETE t1 FROM tags t1
-				INNER JOIN tags t2 
-				WHERE t1.id > t2.id AND t1.name = t2.name
-			`)
-			if err != nil {
-				fmt.Printf("Failed to clean duplicate tags: %v\n", err)
-			} else {
-				fmt.Printf("Cleaned duplicate tags\n")
-			}
+func printIntegrity(report *repository.IntegrityReport) {
+	for _, table := range sortedTables(report.Orphans) {
+		if n := report.Orphans[table]; n > 0 {
+			fmt.Printf("Found %d orphaned %s records\n", n, table)
+		} else {
+			fmt.Printf("No orphaned %s records found\n", table)
 		}
+	}
+	if report.DuplicateTags > 0 {
+		fmt.Printf("⚠️  Found %d duplicate tag names\n", report.DuplicateTags)
 	} else {
 		fmt.Println("No duplicate tags found")
 	}
 }
+
+func sortedTables(counts map[string]int) []string {
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
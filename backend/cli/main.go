@@ -31,6 +31,10 @@ func main() {
 		commands.Backup(cfg)
 	case "status":
 		commands.Status(cfg)
+	case "reindex":
+		commands.Reindex(cfg)
+	case "move":
+		commands.Move(cfg)
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -46,21 +50,25 @@ func printUsage() {
 	fmt.Println("Usage: go run cli/main.go <command> [options]")
 	fmt.Println("")
 	fmt.Println("Commands:")
-	fmt.Println("  migrate   - Run database migrations")
-	fmt.Println("  restore   - Restore database from backup")
+	fmt.Println("  migrate   - Apply, roll back or list schema migrations")
+	fmt.Println("  restore   - Restore database and articles from a backup archive")
 	fmt.Println("  fix       - Fix common database issues")
-	fmt.Println("  backup    - Create database backup")
+	fmt.Println("  backup    - Archive the database and the articles directory")
 	fmt.Println("  status    - Check database status")
+	fmt.Println("  reindex   - Rebuild the full-text search index")
+	fmt.Println("  move      - Move or rename an article and rewrite links to it")
 	fmt.Println("  help      - Show this help message")
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Println("  go run cli/main.go migrate")
-	fmt.Println("  go run cli/main.go migrate --init=init")
-	fmt.Println("  go run cli/main.go migrate --init=update")
-	fmt.Println("  go run cli/main.go backup --output=backup_$(date +%Y%m%d).sql")
-	fmt.Println("  go run cli/main.go restore")
-	fmt.Println("  go run cli/main.go restore --force")
-	fmt.Println("  go run cli/main.go restore --migrate-file=update")
-	fmt.Println("  go run cli/main.go restore --force --migrate-file=update")
+	fmt.Println("  go run cli/main.go migrate up")
+	fmt.Println("  go run cli/main.go migrate down 1")
+	fmt.Println("  go run cli/main.go migrate status")
+	fmt.Println("  go run cli/main.go backup --output=backups/pkms.tar.gz")
+	fmt.Println("  go run cli/main.go restore --from=backups/pkms.tar.gz")
+	fmt.Println("  go run cli/main.go restore --from=backups/pkms.tar.gz --dry-run")
+	fmt.Println("  go run cli/main.go restore --from=backups/pkms.tar.gz --force")
 	fmt.Println("  go run cli/main.go fix --check-only")
+	fmt.Println("  go run cli/main.go reindex --force")
+	fmt.Println("  go run cli/main.go move --from=Food/apple.md --to=Recipes/apple-pie.md")
 }
@@ -3,29 +3,62 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
-	DBHost     string
-	DBPort     int
-	DBUser     string
-	DBPassword string
-	DBName     string
-	ServerPort string
-	SearchPath string
+	DBDriver string // "mysql" or "sqlite"
+	DBPath   string // database file used by the sqlite driver
+	// AutoMigrate applies pending migrations when the server starts.
+	AutoMigrate bool
+	DBHost      string
+	DBPort      int
+	DBUser      string
+	DBPassword  string
+	DBName      string
+	ServerPort  string
+	SearchPath  string
+	// WatchMode is "auto" (inotify, polling fallback), "poll" or "off".
+	WatchMode     string
+	WatchInterval time.Duration // polling interval
+	// SearchTimeout bounds the time spent answering one search request.
+	SearchTimeout time.Duration
+	// RegexMaxBytes is how much of the article files one regex search
+	// may read.
+	RegexMaxBytes int64
 }
 
 func LoadConfig() *Config {
 	port, _ := strconv.Atoi(getEnv("DB_PORT", "3306"))
+	autoMigrate, _ := strconv.ParseBool(getEnv("DB_AUTO_MIGRATE", "true"))
+	watchInterval, err := time.ParseDuration(getEnv("WATCH_INTERVAL", "2s"))
+	if err != nil || watchInterval <= 0 {
+		watchInterval = 2 * time.Second
+	}
+	searchTimeout, err := time.ParseDuration(getEnv("SEARCH_TIMEOUT", "10s"))
+	if err != nil || searchTimeout <= 0 {
+		searchTimeout = 10 * time.Second
+	}
+	regexMaxBytes, err := strconv.ParseInt(getEnv("SEARCH_REGEX_MAX_BYTES", "67108864"), 10, 64)
+	if err != nil || regexMaxBytes <= 0 {
+		regexMaxBytes = 64 << 20
+	}
 
 	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     port,
-		DBUser:     getEnv("DB_USER", "root"),
-		DBPassword: getEnv("DB_PASSWORD", "password"),
-		DBName:     getEnv("DB_NAME", "pkms"),
-		ServerPort: getEnv("SERVER_PORT", "8080"),
-		SearchPath: getEnv("SEARCH_PATH", "/app/articles"),
+		DBDriver:      getEnv("DB_DRIVER", "mysql"),
+		DBPath:        getEnv("DB_PATH", "pkms.db"),
+		AutoMigrate:   autoMigrate,
+		DBHost:        getEnv("DB_HOST", "localhost"),
+		DBPort:        port,
+		DBUser:        getEnv("DB_USER", "root"),
+		DBPassword:    getEnv("DB_PASSWORD", "password"),
+		DBName:        getEnv("DB_NAME", "pkms"),
+		ServerPort:    getEnv("SERVER_PORT", "8080"),
+		SearchPath:    getEnv("SEARCH_PATH", "/app/articles"),
+		WatchMode:     getEnv("WATCH_MODE", "auto"),
+		WatchInterval: watchInterval,
+		SearchTimeout: searchTimeout,
+		RegexMaxBytes: regexMaxBytes,
 	}
 }
 
@@ -0,0 +1,11 @@
+// Package db holds the SQL files of the backend. The numbered migration
+// scripts are embedded so the server and the CLI can apply them without
+// access to the source tree.
+package db
+
+import "embed"
+
+// Migrations contains migrations/<driver>/<version>_<name>.{up,down}.sql.
+//
+//go:embed migrations
+var Migrations embed.FS
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS search_index;
+DROP TABLE IF EXISTS article_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS articles;
@@ -0,0 +1,26 @@
+-- Replace the unused search_index table with an inverted index: one row
+-- per (term, field, article) with the term frequency and token positions.
+-- Terms are compared byte for byte; the tokenizer already lower cases them.
+DROP TABLE IF EXISTS search_index;
+
+CREATE TABLE search_index (
+    term VARCHAR(100) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+    field VARCHAR(10) NOT NULL,
+    article_id BIGINT UNSIGNED NOT NULL,
+    tf INT UNSIGNED NOT NULL,
+    positions TEXT NOT NULL,
+    PRIMARY KEY (term, field, article_id),
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE,
+    INDEX idx_search_article_id (article_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- One row per indexed article: field lengths for ranking and the checksum
+-- of the file the postings were built from.
+CREATE TABLE search_documents (
+    article_id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+    title_length INT UNSIGNED NOT NULL,
+    body_length INT UNSIGNED NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    indexed_at DATETIME NOT NULL,
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+DELETE FROM search_index;
+DELETE FROM search_documents;
+
+ALTER TABLE search_documents
+    DROP COLUMN tags_length,
+    DROP COLUMN heading_length;
@@ -0,0 +1,11 @@
+-- Tags and headings become indexed fields with their own lengths, which the
+-- existing rows were built without.
+--
+-- This and the later migrations that change what the index holds only empty
+-- it: the server rebuilds an empty index on start (or run `cli reindex`).
+ALTER TABLE search_documents
+    ADD COLUMN tags_length INT UNSIGNED NOT NULL DEFAULT 0 AFTER title_length,
+    ADD COLUMN heading_length INT UNSIGNED NOT NULL DEFAULT 0 AFTER tags_length;
+
+DELETE FROM search_index;
+DELETE FROM search_documents;
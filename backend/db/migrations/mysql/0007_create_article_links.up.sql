@@ -0,0 +1,13 @@
+-- Links from an article body to other notes, as written: the path of a
+-- [text](path.md) link or the name of a [[wiki link]].
+-- Links are parsed when an article is indexed, so the index is emptied to fill the table.
+CREATE TABLE article_links (
+    article_id BIGINT UNSIGNED NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    wiki BOOLEAN NOT NULL,
+    PRIMARY KEY (article_id, target, wiki),
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+DELETE FROM search_index;
+DELETE FROM search_documents;
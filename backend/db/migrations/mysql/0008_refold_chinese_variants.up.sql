@@ -0,0 +1,3 @@
+-- Rows folded with the old variant table (乾 as 干, 後 as 后, 苧 apart from 苎) miss queries.
+DELETE FROM search_index;
+DELETE FROM search_documents;
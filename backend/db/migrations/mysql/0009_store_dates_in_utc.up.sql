@@ -0,0 +1,3 @@
+-- Article dates are now stored in UTC. The MySQL driver already converts
+-- times to its connection location (UTC) and DATETIME columns compare as
+-- dates, so nothing has to be converted.
@@ -0,0 +1,14 @@
+-- Create article_properties table for custom frontmatter fields.
+-- value holds the JSON encoding of the field; text and num are derived from
+-- it so scalar properties can be filtered in SQL.
+CREATE TABLE IF NOT EXISTS article_properties (
+    article_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    value TEXT NOT NULL,
+    text VARCHAR(255),
+    num DOUBLE,
+    PRIMARY KEY (article_id, name),
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_property_text ON article_properties (name, text);
+CREATE INDEX IF NOT EXISTS idx_property_num ON article_properties (name, num);
@@ -0,0 +1,25 @@
+-- Replace the unused search_index table with an inverted index: one row
+-- per (term, field, article) with the term frequency and token positions.
+DROP TABLE IF EXISTS search_index;
+
+CREATE TABLE search_index (
+    term VARCHAR(100) NOT NULL,
+    field VARCHAR(10) NOT NULL,
+    article_id INTEGER NOT NULL,
+    tf INTEGER NOT NULL,
+    positions TEXT NOT NULL,
+    PRIMARY KEY (term, field, article_id),
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_search_article_id ON search_index (article_id);
+
+-- One row per indexed article: field lengths for ranking and the checksum
+-- of the file the postings were built from.
+CREATE TABLE search_documents (
+    article_id INTEGER NOT NULL PRIMARY KEY,
+    title_length INTEGER NOT NULL,
+    body_length INTEGER NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    indexed_at DATETIME NOT NULL,
+    FOREIGN KEY (article_id) REFERENCES articles(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DELETE FROM search_index;
+DELETE FROM search_documents;
+
+ALTER TABLE search_documents DROP COLUMN tags_length;
+ALTER TABLE search_documents DROP COLUMN heading_length;
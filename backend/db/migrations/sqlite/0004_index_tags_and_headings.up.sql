@@ -0,0 +1,10 @@
+-- Tags and headings become indexed fields with their own lengths, which the
+-- existing rows were built without.
+--
+-- This and the later migrations that change what the index holds only empty
+-- it: the server rebuilds an empty index on start (or run `cli reindex`).
+ALTER TABLE search_documents ADD COLUMN tags_length INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE search_documents ADD COLUMN heading_length INTEGER NOT NULL DEFAULT 0;
+
+DELETE FROM search_index;
+DELETE FROM search_documents;
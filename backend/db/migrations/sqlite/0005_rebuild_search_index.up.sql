@@ -0,0 +1,3 @@
+-- Rows built before CJK bigrams and full-width folding never match new queries.
+DELETE FROM search_index;
+DELETE FROM search_documents;
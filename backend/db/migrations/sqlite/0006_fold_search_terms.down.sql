@@ -0,0 +1,4 @@
+-- Rows built with folded terms are dropped as well; reindex after rolling
+-- back.
+DELETE FROM search_index;
+DELETE FROM search_documents;
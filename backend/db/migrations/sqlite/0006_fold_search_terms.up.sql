@@ -0,0 +1,3 @@
+-- Terms are now case folded and stripped of accents ("café" is indexed as "cafe").
+DELETE FROM search_index;
+DELETE FROM search_documents;
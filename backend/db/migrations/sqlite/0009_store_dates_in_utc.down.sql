@@ -0,0 +1 @@
+-- UTC dates stay valid for the previous version; nothing to undo.
@@ -0,0 +1,8 @@
+-- Article dates are now stored in UTC. SQLite keeps them as text with the
+-- offset they were written with and compares the text, so dates written in
+-- local time are converted (to millisecond precision); dates already in
+-- UTC are left as they are.
+UPDATE articles SET create_date = strftime('%Y-%m-%d %H:%M:%f', create_date) || '+00:00'
+WHERE create_date NOT LIKE '%+00:00' AND strftime('%Y-%m-%d %H:%M:%f', create_date) IS NOT NULL;
+UPDATE articles SET edit_date = strftime('%Y-%m-%d %H:%M:%f', edit_date) || '+00:00'
+WHERE edit_date NOT LIKE '%+00:00' AND strftime('%Y-%m-%d %H:%M:%f', edit_date) IS NOT NULL;
@@ -0,0 +1,301 @@
+// Package frontmatter reads and writes the YAML block at the top of an
+// article file:
+//
+//	---
+//	title: "USB"
+//	tags: ["3C"]
+//	---
+//
+//	# Introduction
+//
+// Documents keep the YAML as a node tree, so keys the application does not
+// know about, their order and their comments survive a Parse / Bytes round
+// trip, and the body is returned byte for byte.
+package frontmatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalid = errors.New("invalid frontmatter")
+
+const delimiter = "---"
+
+// Document is an article file split into its frontmatter and body.
+type Document struct {
+	// meta is the frontmatter mapping, nil when the file has none.
+	meta *yaml.Node
+	// Body is everything after the closing delimiter line.
+	Body string
+}
+
+// New returns a document without frontmatter.
+func New(body string) *Document {
+	return &Document{Body: body}
+}
+
+// Parse splits content into frontmatter and body. Content that does not
+// start with a "---" line, or whose block is never closed, has no
+// frontmatter and is kept entirely as the body.
+func Parse(content []byte) (*Document, error) {
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	raw, body, ok := split(text)
+	if !ok {
+		return &Document{Body: string(content)}, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	meta := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		switch node := doc.Content[0]; {
+		case node.Kind == yaml.MappingNode:
+			meta = node
+		case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+			// only comments or an explicit null
+		default:
+			return nil, fmt.Errorf("%w: expected a mapping, got %s", ErrInvalid, node.ShortTag())
+		}
+	}
+	return &Document{meta: meta, Body: body}, nil
+}
+
+// split returns the text between the opening and the closing delimiter line
+// and the rest of the document.
+func split(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, delimiter+"\n") && !strings.HasPrefix(text, delimiter+"\r\n") {
+		return "", text, false
+	}
+	rest := text[strings.Index(text, "\n")+1:]
+	for offset := 0; offset < len(rest); {
+		end := strings.Index(rest[offset:], "\n")
+		line := rest[offset:]
+		if end != -1 {
+			line = rest[offset : offset+end]
+		}
+		if l := strings.TrimRight(line, "\r"); l == delimiter || l == "..." {
+			if end == -1 {
+				return rest[:offset], "", true
+			}
+			return rest[:offset], rest[offset+end+1:], true
+		}
+		if end == -1 {
+			break
+		}
+		offset += end + 1
+	}
+	return "", text, false
+}
+
+// HasFrontmatter reports whether the document has a frontmatter block,
+// possibly empty.
+func (d *Document) HasFrontmatter() bool {
+	return d.meta != nil
+}
+
+// Keys returns the frontmatter keys in file order.
+func (d *Document) Keys() []string {
+	if d.meta == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(d.meta.Content)/2)
+	for i := 0; i+1 < len(d.meta.Content); i += 2 {
+		keys = append(keys, d.meta.Content[i].Value)
+	}
+	return keys
+}
+
+// Has reports whether key is present, even with an empty value.
+func (d *Document) Has(key string) bool {
+	return d.index(key) >= 0
+}
+
+// Decode stores the value of key in v. It returns false when the key is
+// missing.
+func (d *Document) Decode(key string, v interface{}) (bool, error) {
+	i := d.index(key)
+	if i < 0 {
+		return false, nil
+	}
+	if err := d.meta.Content[i+1].Decode(v); err != nil {
+		return true, fmt.Errorf("%w: %s: %v", ErrInvalid, key, err)
+	}
+	return true, nil
+}
+
+// String returns the value of a scalar key. Null values are reported as
+// missing.
+func (d *Document) String(key string) (string, bool, error) {
+	i := d.index(key)
+	if i < 0 {
+		return "", false, nil
+	}
+	node := d.meta.Content[i+1]
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return "", false, nil
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", true, fmt.Errorf("%w: %s must be a single value", ErrInvalid, key)
+	}
+	return node.Value, true, nil
+}
+
+// StringList returns a list value. A scalar is read as a comma separated
+// list, so both `tags: [a, b]` and `tags: a, b` work. Items are trimmed and
+// empty or repeated items dropped. Null values are reported as missing.
+func (d *Document) StringList(key string) ([]string, bool, error) {
+	i := d.index(key)
+	if i < 0 {
+		return nil, false, nil
+	}
+	node := d.meta.Content[i+1]
+	var items []string
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+		return nil, false, nil
+	case node.Kind == yaml.ScalarNode:
+		items = strings.Split(node.Value, ",")
+	case node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, true, fmt.Errorf("%w: %s must be a list of values", ErrInvalid, key)
+			}
+			items = append(items, item.Value)
+		}
+	default:
+		return nil, true, fmt.Errorf("%w: %s must be a list", ErrInvalid, key)
+	}
+
+	list := []string{}
+	seen := map[string]struct{}{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if _, dup := seen[item]; item == "" || dup {
+			continue
+		}
+		seen[item] = struct{}{}
+		list = append(list, item)
+	}
+	return list, true, nil
+}
+
+// Set replaces the value of key in place, or appends the key when it is
+// new. A replaced value keeps the quoting and flow style of the old one;
+// new strings are double quoted and new lists written inline, like the
+// files the application creates.
+func (d *Document) Set(key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	if d.meta == nil {
+		d.meta = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	if i := d.index(key); i >= 0 {
+		old := d.meta.Content[i+1]
+		restyle(&node, old)
+		node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		d.meta.Content[i+1] = &node
+		return nil
+	}
+	restyle(&node, nil)
+	d.meta.Content = append(d.meta.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&node,
+	)
+	return nil
+}
+
+// Delete removes key and reports whether it was present.
+func (d *Document) Delete(key string) bool {
+	i := d.index(key)
+	if i < 0 {
+		return false
+	}
+	d.meta.Content = append(d.meta.Content[:i], d.meta.Content[i+2:]...)
+	return true
+}
+
+// Bytes serializes the document. A document without frontmatter is
+// returned as its body.
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if d.meta != nil {
+		buf.WriteString(delimiter + "\n")
+		if len(d.meta.Content) > 0 {
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(d.meta); err != nil {
+				return nil, err
+			}
+			if err := enc.Close(); err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteString(delimiter + "\n")
+	}
+	buf.WriteString(d.Body)
+	return buf.Bytes(), nil
+}
+
+func (d *Document) index(key string) int {
+	if d.meta == nil {
+		return -1
+	}
+	for i := 0; i+1 < len(d.meta.Content); i += 2 {
+		if d.meta.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// restyle copies the style of old onto node, or applies the default style
+// when old is nil or of another kind. Only strings are quoted: numbers,
+// booleans and strings that need it are left to the encoder.
+func restyle(node, old *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		style := yaml.DoubleQuotedStyle
+		if old != nil && old.Kind == yaml.ScalarNode {
+			style = old.Style &^ (yaml.TaggedStyle | yaml.FlowStyle)
+		}
+		if node.Tag == "!!str" && (style != 0 || needsQuotes(node.Value)) {
+			if style == 0 {
+				style = yaml.DoubleQuotedStyle
+			}
+			node.Style = style
+		}
+	case yaml.SequenceNode:
+		node.Style = yaml.FlowStyle
+		var item *yaml.Node
+		if old != nil && old.Kind == yaml.SequenceNode {
+			node.Style = old.Style & yaml.FlowStyle
+			if len(old.Content) > 0 {
+				item = old.Content[0]
+			}
+		}
+		for _, child := range node.Content {
+			restyle(child, item)
+		}
+	}
+}
+
+// needsQuotes reports whether a plain string would be read back as another
+// type or not at all; the encoder only quotes those when it picks the style.
+func needsQuotes(s string) bool {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return true
+	}
+	str, ok := v.(string)
+	return !ok || str != s
+}
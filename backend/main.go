@@ -1,14 +1,11 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
 	"log"
 
-	_ "github.com/go-sql-driver/mysql"
-
 	"pkms/backend/api"
 	"pkms/backend/config"
+	"pkms/backend/repository"
 	"pkms/backend/services"
 
 	"github.com/gin-contrib/cors"
@@ -19,27 +16,72 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	// Initialize DB
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-	db, err := sql.Open("mysql", dsn)
+	// Initialize DB (mysql or sqlite, see DB_DRIVER)
+	repo, err := repository.Open(cfg)
 	if err != nil {
 		log.Fatal("failed to connect to db:", err)
 	}
-	defer db.Close()
+	defer repo.Close()
+
+	if cfg.AutoMigrate {
+		migrator, err := repository.NewMigrator(repo)
+		if err != nil {
+			log.Fatal("failed to load migrations:", err)
+		}
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatal("failed to migrate db:", err)
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+		}
+	}
 
 	// Initialize services
 	contentService := services.NewContentService(cfg)
-	articleService := services.NewArticleService(db)
+	articleService := services.NewArticleService(repo)
+	indexService := services.NewIndexService(repo, cfg)
+	suggestService := services.NewSuggestService(repo)
+	articleService.SetSuggestService(suggestService)
+	indexService.SetSuggestService(suggestService)
+
+	// Catch up the search index with files changed while the server was down
+	go func() {
+		report, err := indexService.Reindex(false)
+		if err != nil {
+			log.Println("failed to update search index:", err)
+			return
+		}
+		for _, e := range report.Errors {
+			log.Printf("search index: %s: %s", e.Path, e.Err)
+		}
+		if report.Indexed > 0 {
+			log.Printf("search index: indexed %d articles", report.Indexed)
+		}
+	}()
+
+	// Keep the DB in sync with edits made outside of the API
+	if cfg.WatchMode != services.WatchOff {
+		syncService := services.NewSyncService(repo, cfg)
+		syncService.SetSuggestService(suggestService)
+		watcher := services.NewWatcher(syncService, cfg)
+		if err := watcher.Start(); err != nil {
+			log.Fatal("failed to watch articles:", err)
+		}
+		defer watcher.Close()
+		articleService.SetWatcher(watcher)
+	}
 
 	// Initialize handlers
 	contentHandler := api.NewContentHandler(contentService, articleService)
 	hierarchyHandler := api.NewHierarchyHandler(cfg)
-	tagHandler := api.NewTagHandler(db)
-	searchHandler := api.NewSearchHandler(db, contentService)
+	tagHandler := api.NewTagHandler(repo)
+	relatedHandler := api.NewRelatedHandler(services.NewRelatedService(repo))
+	searchHandler := api.NewSearchHandler(services.NewSearchService(repo, indexService, cfg), suggestService)
 
 	// 新增 ArticleHandler
 	articleHandler := api.NewArticleHandler(articleService, cfg)
+	folderHandler := api.NewFolderHandler(articleService, cfg)
 
 	// Setup router
 	r := gin.Default()
@@ -59,16 +101,24 @@ func main() {
 		// Hierarchy route
 		apiGroup.GET("/hierarchy", hierarchyHandler.GetHierarchy)
 
+		// Folder routes
+		apiGroup.POST("/folders", folderHandler.CreateFolder)
+		apiGroup.PUT("/folders", folderHandler.MoveFolder)
+		apiGroup.DELETE("/folders", folderHandler.DeleteFolder)
+
 		// Article routes
 		apiGroup.POST("/articles", articleHandler.CreateArticle)
 		apiGroup.DELETE("/articles/:id", articleHandler.DeleteArticle)
 		apiGroup.PUT("/articles/:id", articleHandler.UpdateArticle)
+		apiGroup.POST("/articles/:id/move", articleHandler.MoveArticle)
+		apiGroup.GET("/articles/:id/related", relatedHandler.GetRelatedArticles)
 
 		// Tag routes
 		apiGroup.GET("/tags", tagHandler.GetTags)
 
 		// Search routes
 		apiGroup.GET("/search", searchHandler.SearchArticles)
+		apiGroup.GET("/search/suggest", searchHandler.Suggest)
 	}
 
 	// Start server
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,11 +10,15 @@ type Article struct {
 	ID         uint      `json:"id" gorm:"primaryKey"`
 	Title      string    `json:"title" gorm:"not null"`
 	Path       string    `json:"path" gorm:"uniqueIndex;not null"`
-	Content    string    `json:"content" gorm:"type:text"`
+	Type       string    `json:"type" gorm:"not null"`
+	Content    string    `json:"content,omitempty" gorm:"type:text"`
 	CreateDate time.Time `json:"create_date" gorm:"not null"`
 	EditDate   time.Time `json:"edit_date" gorm:"not null"`
 	RefCount   int       `json:"ref_count" gorm:"default:0"`
-	Tags       []Tag     `json:"tags" gorm:"many2many:article_tags;"`
+	Pin        bool      `json:"pin" gorm:"default:false"`
+	Tags       []Tag     `json:"tags,omitempty" gorm:"many2many:article_tags;"`
+	// Properties holds the custom frontmatter fields of the article.
+	Properties map[string]interface{} `json:"properties,omitempty" gorm:"-"`
 }
 
 // Tag represents an article tag
@@ -24,6 +29,22 @@ type Tag struct {
 
 // ArticleTag represents the many-to-many relationship between articles and tags
 type ArticleTag struct {
-	ArticleID uint `gorm:"primaryKey"`
-	TagID     uint `gorm:"primaryKey"`
+	ArticleID uint `json:"article_id" gorm:"primaryKey"`
+	TagID     uint `json:"tag_id" gorm:"primaryKey"`
+}
+
+// ArticleLink is a link of an article body to another note, as written:
+// the path of a markdown link or the name of a wiki link.
+type ArticleLink struct {
+	ArticleID uint   `json:"article_id" gorm:"primaryKey"`
+	Target    string `json:"target" gorm:"primaryKey"`
+	Wiki      bool   `json:"wiki" gorm:"primaryKey"`
+}
+
+// ArticleProperty is one custom frontmatter field of an article. Value is
+// the JSON encoding of the field.
+type ArticleProperty struct {
+	ArticleID uint            `json:"article_id" gorm:"primaryKey"`
+	Name      string          `json:"name" gorm:"primaryKey"`
+	Value     json.RawMessage `json:"value" gorm:"type:text;not null"`
 }
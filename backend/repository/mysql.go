@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"pkms/backend/config"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// MySQLRepository stores articles in the MySQL database described by the
+// DB_* settings (the docker-compose setup).
+type MySQLRepository struct {
+	sqlRepository
+}
+
+func NewMySQL(cfg *config.Config) (*MySQLRepository, error) {
+	db, err := sql.Open("mysql", MySQLDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return &MySQLRepository{sqlRepository{db: db, driver: "mysql"}}, nil
+}
+
+// MySQLDSN builds the go-sql-driver DSN for cfg.
+func MySQLDSN(cfg *config.Config) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+}
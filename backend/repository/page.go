@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkms/backend/models"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// Sort fields of FindArticles.
+const (
+	SortID         = "id"
+	SortTitle      = "title"
+	SortCreateDate = "create_date"
+	SortEditDate   = "edit_date"
+	SortRefCount   = "ref_count"
+)
+
+// sortDefaultDesc tells the direction of a sort field when none is given:
+// newest and most referenced first, titles from A to Z.
+var sortDefaultDesc = map[string]bool{
+	SortID:         false,
+	SortTitle:      false,
+	SortCreateDate: true,
+	SortEditDate:   true,
+	SortRefCount:   true,
+}
+
+// ArticleSort orders FindArticles by a column, ties broken by id. The zero
+// value sorts by id.
+type ArticleSort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseArticleSort reads "field", "field:asc" or "field:desc".
+func ParseArticleSort(expr string) (ArticleSort, error) {
+	field, dir := expr, ""
+	if i := strings.IndexByte(expr, ':'); i >= 0 {
+		field, dir = expr[:i], expr[i+1:]
+	}
+	desc, ok := sortDefaultDesc[field]
+	if !ok {
+		return ArticleSort{}, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, field)
+	}
+	switch dir {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return ArticleSort{}, fmt.Errorf("%w: direction must be asc or desc, got %q", ErrInvalidSort, dir)
+	}
+	return ArticleSort{Field: field, Desc: desc}, nil
+}
+
+func (s ArticleSort) String() string {
+	field := s.Field
+	if field == "" {
+		field = SortID
+	}
+	if s.Desc {
+		return field + ":desc"
+	}
+	return field + ":asc"
+}
+
+// ArticleCursor is the position of an article in a sort order: its id and
+// the value of the sort column in text form.
+type ArticleCursor struct {
+	ID    uint
+	Value string
+}
+
+// Cursor returns the position of article in the sort order, for the page
+// after it.
+func (s ArticleSort) Cursor(article *models.Article) ArticleCursor {
+	cursor := ArticleCursor{ID: article.ID}
+	switch s.Field {
+	case SortTitle:
+		cursor.Value = article.Title
+	case SortCreateDate:
+		cursor.Value = article.CreateDate.Format(time.RFC3339Nano)
+	case SortEditDate:
+		cursor.Value = article.EditDate.Format(time.RFC3339Nano)
+	case SortRefCount:
+		cursor.Value = strconv.Itoa(article.RefCount)
+	}
+	return cursor
+}
+
+// Compare orders two articles: negative when a comes first, positive when
+// b does. It agrees with FindArticles up to the collation of titles.
+func (s ArticleSort) Compare(a, b *models.Article) int {
+	c := 0
+	switch s.Field {
+	case SortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case SortCreateDate:
+		c = compareTime(a.CreateDate, b.CreateDate)
+	case SortEditDate:
+		c = compareTime(a.EditDate, b.EditDate)
+	case SortRefCount:
+		c = a.RefCount - b.RefCount
+	}
+	if c == 0 {
+		c = int(a.ID) - int(b.ID)
+	}
+	if s.Desc {
+		return -c
+	}
+	return c
+}
+
+// IsAfter tells whether article comes after cursor in the sort order.
+func (s ArticleSort) IsAfter(article *models.Article, cursor ArticleCursor) (bool, error) {
+	at, err := s.cursorArticle(cursor)
+	if err != nil {
+		return false, err
+	}
+	return s.Compare(article, at) > 0, nil
+}
+
+// Helper functions
+
+func (s ArticleSort) column() string {
+	if s.Field == "" {
+		return "a." + SortID
+	}
+	return "a." + s.Field
+}
+
+// orderBy returns the ORDER BY clause of the sort.
+func (s ArticleSort) orderBy() string {
+	dir := " ASC"
+	if s.Desc {
+		dir = " DESC"
+	}
+	if s.column() == "a.id" {
+		return " ORDER BY a.id" + dir
+	}
+	return " ORDER BY " + s.column() + dir + ", a.id" + dir
+}
+
+// after returns the condition selecting the articles after cursor.
+func (s ArticleSort) after(cursor ArticleCursor) (string, []interface{}, error) {
+	op := ">"
+	if s.Desc {
+		op = "<"
+	}
+	at, err := s.cursorArticle(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	var value interface{}
+	switch s.Field {
+	case "", SortID:
+		return "a.id " + op + " ?", []interface{}{cursor.ID}, nil
+	case SortTitle:
+		value = at.Title
+	case SortCreateDate:
+		value = dbTime(at.CreateDate)
+	case SortEditDate:
+		value = dbTime(at.EditDate)
+	case SortRefCount:
+		value = at.RefCount
+	}
+	col := s.column()
+	cond := fmt.Sprintf("(%s %s ? OR (%s = ? AND a.id %s ?))", col, op, col, op)
+	return cond, []interface{}{value, value, cursor.ID}, nil
+}
+
+// cursorArticle is the reverse of Cursor: an article holding the id and
+// sort column of cursor.
+func (s ArticleSort) cursorArticle(cursor ArticleCursor) (*models.Article, error) {
+	at := &models.Article{ID: cursor.ID}
+	var err error
+	switch s.Field {
+	case SortTitle:
+		at.Title = cursor.Value
+	case SortCreateDate:
+		at.CreateDate, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case SortEditDate:
+		at.EditDate, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case SortRefCount:
+		at.RefCount, err = strconv.Atoi(cursor.Value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalidSort)
+	}
+	return at, nil
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
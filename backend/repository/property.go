@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pkms/backend/models"
+)
+
+var ErrInvalidPropertyFilter = errors.New("invalid property filter")
+
+// PropertyOp is the comparison of a PropertyFilter.
+type PropertyOp string
+
+const (
+	PropertyExists       PropertyOp = ""
+	PropertyEqual        PropertyOp = "="
+	PropertyNotEqual     PropertyOp = "!="
+	PropertyLess         PropertyOp = "<"
+	PropertyLessEqual    PropertyOp = "<="
+	PropertyGreater      PropertyOp = ">"
+	PropertyGreaterEqual PropertyOp = ">="
+)
+
+// propertyOps is ordered so that two character operators match first.
+var propertyOps = []PropertyOp{
+	PropertyNotEqual, PropertyLessEqual, PropertyGreaterEqual,
+	PropertyEqual, PropertyLess, PropertyGreater,
+}
+
+// maxPropertyText is the size of the article_properties.text column.
+const maxPropertyText = 255
+
+// PropertyFilter matches articles by a custom property. Equality ignores
+// case; ordering compares numbers when Value is a number and text
+// otherwise, so ISO dates compare as expected. Like the other comparisons,
+// PropertyNotEqual needs the property: "status!=draft" skips articles
+// without a status. Only single values can be compared, list and map
+// properties only match PropertyExists.
+type PropertyFilter struct {
+	Name  string
+	Op    PropertyOp
+	Value string
+}
+
+// ParsePropertyFilter reads "name", "name=value", "name!=value",
+// "name>value", "name>=value", "name<value" or "name<=value".
+func ParsePropertyFilter(expr string) (PropertyFilter, error) {
+	for i := 0; i < len(expr); i++ {
+		for _, op := range propertyOps {
+			if !strings.HasPrefix(expr[i:], string(op)) {
+				continue
+			}
+			filter := PropertyFilter{
+				Name:  strings.TrimSpace(expr[:i]),
+				Op:    op,
+				Value: strings.TrimSpace(expr[i+len(op):]),
+			}
+			if filter.Name == "" {
+				return filter, fmt.Errorf("%w: %q has no property name", ErrInvalidPropertyFilter, expr)
+			}
+			return filter, nil
+		}
+	}
+	name := strings.TrimSpace(expr)
+	if name == "" {
+		return PropertyFilter{}, fmt.Errorf("%w: empty filter", ErrInvalidPropertyFilter)
+	}
+	return PropertyFilter{Name: name, Op: PropertyExists}, nil
+}
+
+// where returns the SQL condition on articles a for the filter.
+func (f PropertyFilter) where() (string, []interface{}) {
+	const exists = "EXISTS (SELECT 1 FROM article_properties p WHERE p.article_id = a.id AND p.name = ?"
+	switch f.Op {
+	case PropertyExists:
+		return exists + ")", []interface{}{f.Name}
+	case PropertyEqual:
+		return exists + " AND LOWER(p.text) = LOWER(?))", []interface{}{f.Name, f.Value}
+	case PropertyNotEqual:
+		return exists + " AND LOWER(p.text) <> LOWER(?))", []interface{}{f.Name, f.Value}
+	}
+	if num, err := strconv.ParseFloat(f.Value, 64); err == nil {
+		return exists + " AND p.num " + string(f.Op) + " ?)", []interface{}{f.Name, num}
+	}
+	return exists + " AND p.text " + string(f.Op) + " ?)", []interface{}{f.Name, f.Value}
+}
+
+// propertyColumns derives the filterable columns of a property value.
+func propertyColumns(value interface{}) (text, num interface{}) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v), float64(v)
+	case int64:
+		return strconv.FormatInt(v, 10), float64(v)
+	case uint64:
+		return strconv.FormatUint(v, 10), float64(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), v
+	default:
+		return nil, nil
+	}
+	if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		num = n
+	}
+	if r := []rune(s); len(r) > maxPropertyText {
+		s = string(r[:maxPropertyText])
+	}
+	return s, num
+}
+
+func articleProperties(q queryer, id uint) (map[string]interface{}, error) {
+	rows, err := q.Query("SELECT name, value FROM article_properties WHERE article_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	properties := map[string]interface{}{}
+	for rows.Next() {
+		var name, raw string
+		if err := rows.Scan(&name, &raw); err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("property %s of article %d: %w", name, id, err)
+		}
+		properties[name] = value
+	}
+	return properties, rows.Err()
+}
+
+func (r *sqlRepository) ArticleProperties(id uint) (map[string]interface{}, error) {
+	return articleProperties(r.db, id)
+}
+
+func (r *sqlRepository) ArticlePropertyRows() ([]models.ArticleProperty, error) {
+	rows, err := r.db.Query("SELECT article_id, name, value FROM article_properties ORDER BY article_id, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var properties []models.ArticleProperty
+	for rows.Next() {
+		var property models.ArticleProperty
+		var raw string
+		if err := rows.Scan(&property.ArticleID, &property.Name, &raw); err != nil {
+			return nil, err
+		}
+		property.Value = json.RawMessage(raw)
+		properties = append(properties, property)
+	}
+	return properties, rows.Err()
+}
+
+func (t *sqlTx) ArticleProperties(id uint) (map[string]interface{}, error) {
+	return articleProperties(t.tx, id)
+}
+
+func (t *sqlTx) SetArticleProperties(id uint, properties map[string]interface{}) error {
+	if _, err := t.tx.Exec("DELETE FROM article_properties WHERE article_id = ?", id); err != nil {
+		return err
+	}
+	for name, value := range properties {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("property %s: %w", name, err)
+		}
+		if err := insertProperty(t.tx, id, name, raw, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) RestoreArticleProperty(property *models.ArticleProperty) error {
+	var value interface{}
+	if err := json.Unmarshal(property.Value, &value); err != nil {
+		return fmt.Errorf("property %s of article %d: %w", property.Name, property.ArticleID, err)
+	}
+	return insertProperty(t.tx, property.ArticleID, property.Name, property.Value, value)
+}
+
+func insertProperty(q queryer, id uint, name string, raw []byte, value interface{}) error {
+	text, num := propertyColumns(value)
+	_, err := q.Exec("INSERT INTO article_properties (article_id, name, value, text, num) VALUES (?, ?, ?, ?, ?)",
+		id, name, string(raw), text, num)
+	return err
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"pkms/backend/config"
+	"pkms/backend/models"
+	"pkms/backend/search"
+)
+
+var (
+	ErrNotFound          = errors.New("record not found")
+	ErrUnsupportedDriver = errors.New("unsupported database driver")
+)
+
+// Repository is the storage layer used by the services. Every backend
+// (MySQL, SQLite) implements the same set of queries so the services never
+// deal with SQL dialects directly.
+type Repository interface {
+	// GetArticle returns the article with the given id or ErrNotFound.
+	GetArticle(id uint) (*models.Article, error)
+	// FindArticles returns the articles matching the filter.
+	FindArticles(filter ArticleFilter) ([]models.Article, error)
+	// CountArticles returns the number of articles matching filter.
+	CountArticles(filter ArticleFilter) (int, error)
+	// Facets counts the articles matching filter per tag, folder, type and
+	// month.
+	Facets(filter ArticleFilter) (*Facets, error)
+	// ArticleTags returns the tag names attached to an article.
+	ArticleTags(id uint) ([]string, error)
+	// TagsOfArticles returns the tag names of the given articles by
+	// article id, in a query per 500 articles.
+	TagsOfArticles(ids []uint) (map[uint][]string, error)
+	// FindTags returns the tags whose name contains query (case-insensitive).
+	// An empty query returns every tag.
+	FindTags(query string) ([]models.Tag, error)
+	// ArticleTagLinks returns every row of the article_tags table.
+	ArticleTagLinks() ([]models.ArticleTag, error)
+	// ArticleProperties returns the custom properties of an article.
+	ArticleProperties(id uint) (map[string]interface{}, error)
+	// ArticlePropertyRows returns every row of the article_properties table.
+	ArticlePropertyRows() ([]models.ArticleProperty, error)
+
+	// SearchPostings returns the index rows of the terms. With prefix set a
+	// term also matches every longer term starting with it.
+	SearchPostings(terms []string, prefix bool) ([]Posting, error)
+	// SearchTerms returns the distinct indexed terms starting with any of
+	// the prefixes.
+	SearchTerms(prefixes []string) ([]string, error)
+	// ArticlePostings returns the index rows of an article.
+	ArticlePostings(id uint) ([]Posting, error)
+	// TermDocs counts the articles containing each of the terms.
+	TermDocs(terms []string) (map[string]int, error)
+	// FrequentTerms returns the limit indexed terms found in the most
+	// articles, with their article counts.
+	FrequentTerms(limit int) ([]FacetCount, error)
+	// IndexStats returns the document count and average field lengths of
+	// the index, IndexLengths the field lengths of the given articles.
+	IndexStats() (*search.Stats, error)
+	IndexLengths(ids []uint) (map[uint]map[string]int, error)
+	// ArticleLinks returns every link between articles found by the index.
+	ArticleLinks() ([]models.ArticleLink, error)
+	// IndexChecksums maps every indexed article to the checksum of the file
+	// it was indexed from.
+	IndexChecksums() (map[uint]string, error)
+
+	// CheckIntegrity counts orphaned and duplicated rows, RepairIntegrity
+	// deletes them and reports what was removed.
+	CheckIntegrity() (*IntegrityReport, error)
+	RepairIntegrity() (*IntegrityReport, error)
+	// Schema returns the server version and the columns of every table
+	// of the migrations.
+	Schema() (*SchemaInfo, error)
+
+	// Begin starts a transaction for the write operations.
+	Begin() (Tx, error)
+	Close() error
+}
+
+// Tx groups the write operations of a Repository into one transaction.
+type Tx interface {
+	GetArticle(id uint) (*models.Article, error)
+	ArticleTags(id uint) ([]string, error)
+	ArticleProperties(id uint) (map[string]interface{}, error)
+
+	// InsertArticle stores a new article and returns its id.
+	InsertArticle(article *models.Article) (uint, error)
+	// UpdateArticle overwrites title, path, type, pin, create_date and
+	// edit_date.
+	UpdateArticle(article *models.Article) error
+	// DeleteArticle removes an article together with its tag links and
+	// other rows referencing it.
+	DeleteArticle(id uint) error
+	// SetArticleTags replaces the tags of an article, creating missing tags.
+	SetArticleTags(id uint, tags []string) error
+	// SetArticleProperties replaces the custom properties of an article.
+	SetArticleProperties(id uint, properties map[string]interface{}) error
+	// IndexArticle replaces the search index rows and links of an article.
+	IndexArticle(doc *IndexDocument) error
+	// DeleteArticleIndex removes an article from the search index, links
+	// included.
+	DeleteArticleIndex(id uint) error
+
+	// DeleteAll empties every article related table (used by restore).
+	DeleteAll() error
+	// RestoreArticle, RestoreTag, RestoreArticleTag and
+	// RestoreArticleProperty insert rows as they are, keeping their ids (used
+	// by restore).
+	RestoreArticle(article *models.Article) error
+	RestoreTag(tag *models.Tag) error
+	RestoreArticleTag(link *models.ArticleTag) error
+	RestoreArticleProperty(property *models.ArticleProperty) error
+
+	Commit() error
+	Rollback() error
+}
+
+// TagMode tells how ArticleFilter.Tags combine.
+type TagMode string
+
+const (
+	TagsAny TagMode = "any"
+	TagsAll TagMode = "all"
+)
+
+// FacetCount is the number of articles sharing a value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets break the articles matching a filter down by tag, top-level
+// folder ("" for the root), type and month of the last edit ("2024-05").
+// Months are newest first, the others most common first.
+type Facets struct {
+	Tags    []FacetCount `json:"tags"`
+	Folders []FacetCount `json:"folders"`
+	Types   []FacetCount `json:"types"`
+	Months  []FacetCount `json:"months"`
+}
+
+// ArticleFilter narrows FindArticles. Zero values mean "no restriction".
+type ArticleFilter struct {
+	// Path matches articles whose path contains it (case-insensitive).
+	Path string
+	// Tags matches articles carrying any of the tags, or all of them with
+	// TagMode TagsAll. ExcludeTags drops articles carrying any of its tags.
+	Tags        []string
+	TagMode     TagMode
+	ExcludeTags []string
+	// Properties matches articles satisfying every property condition.
+	Properties []PropertyFilter
+	// IDs restricts the result to these articles when it is not nil. Long
+	// lists are queried in chunks of 500 ids.
+	IDs []uint
+	// CreatedAfter / EditedAfter keep the articles dated at or after the
+	// time, CreatedBefore / EditedBefore the ones dated before it.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	EditedAfter   time.Time
+	EditedBefore  time.Time
+
+	// Sort orders the articles, Limit caps their number and After skips
+	// the ones up to a cursor of the same sort. CountArticles ignores them.
+	Sort  ArticleSort
+	Limit int
+	After *ArticleCursor
+}
+
+// IntegrityReport counts rows that reference missing articles or repeat a
+// tag name.
+type IntegrityReport struct {
+	// Orphans maps a table name to its rows whose article no longer exists.
+	Orphans       map[string]int `json:"orphans"`
+	DuplicateTags int            `json:"duplicate_tags"`
+}
+
+// Open returns the Repository selected by cfg.DBDriver.
+func Open(cfg *config.Config) (Repository, error) {
+	var (
+		repo Repository
+		err  error
+	)
+	switch cfg.DBDriver {
+	case "mysql":
+		repo, err = NewMySQL(cfg)
+	case "sqlite", "sqlite3":
+		repo, err = NewSQLite(cfg.DBPath)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnsupportedDriver, cfg.DBDriver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
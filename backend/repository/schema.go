@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"database/sql"
+)
+
+// schemaTables are the tables created by the migrations.
+var schemaTables = []string{
+	"articles", "tags", "article_tags", "article_properties",
+	"search_index", "search_documents", "article_links", "schema_migrations",
+}
+
+// SchemaInfo describes the database: its server version and the columns of
+// the tables created by the migrations.
+type SchemaInfo struct {
+	Driver  string
+	Version string
+	Tables  []TableInfo
+}
+
+// TableInfo lists the columns of a table; Missing is set when the table
+// does not exist, e.g. before the migrations ran.
+type TableInfo struct {
+	Name    string
+	Columns []ColumnInfo
+	Missing bool
+}
+
+// ColumnInfo describes a column. Default is nil without a default value.
+type ColumnInfo struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+	NotNull    bool
+	Default    *string
+}
+
+func (r *sqlRepository) Schema() (*SchemaInfo, error) {
+	info := &SchemaInfo{Driver: r.driver}
+	version := "SELECT VERSION()"
+	if r.driver == "sqlite" {
+		version = "SELECT sqlite_version()"
+	}
+	if err := r.db.QueryRow(version).Scan(&info.Version); err != nil {
+		return nil, err
+	}
+	for _, table := range schemaTables {
+		columns, err := r.columns(table)
+		if err != nil {
+			return nil, err
+		}
+		info.Tables = append(info.Tables, TableInfo{Name: table, Columns: columns, Missing: len(columns) == 0})
+	}
+	return info, nil
+}
+
+// Helper functions
+
+// columns returns the columns of table in their order, none when the table
+// does not exist.
+func (r *sqlRepository) columns(table string) ([]ColumnInfo, error) {
+	var columns []ColumnInfo
+	if r.driver == "sqlite" {
+		// table is one of schemaTables; PRAGMA takes no bind variables
+		rows, err := r.db.Query("PRAGMA table_info(" + table + ")")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var cid, notNull, pk int
+			var c ColumnInfo
+			var def sql.NullString
+			if err := rows.Scan(&cid, &c.Name, &c.Type, &notNull, &def, &pk); err != nil {
+				return nil, err
+			}
+			c.NotNull, c.PrimaryKey = notNull != 0, pk > 0
+			if def.Valid {
+				c.Default = &def.String
+			}
+			columns = append(columns, c)
+		}
+		return columns, rows.Err()
+	}
+
+	rows, err := r.db.Query(`
+		SELECT column_name, column_type, is_nullable, column_key, column_default
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ?
+		ORDER BY ordinal_position
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ColumnInfo
+		var nullable, key string
+		var def sql.NullString
+		if err := rows.Scan(&c.Name, &c.Type, &nullable, &key, &def); err != nil {
+			return nil, err
+		}
+		c.NotNull, c.PrimaryKey = nullable == "NO", key == "PRI"
+		if def.Valid {
+			c.Default = &def.String
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,394 @@
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pkms/backend/models"
+	"pkms/backend/search"
+)
+
+// maxLinkLength is the longest link target stored (the column size).
+const maxLinkLength = 255
+
+// Posting is one row of the inverted index: where a term occurs in one
+// field of an article.
+type Posting struct {
+	ArticleID uint
+	Term      string
+	Field     string
+	TF        int
+	Positions []int
+}
+
+// IndexDocument is everything the index stores for one article.
+type IndexDocument struct {
+	ArticleID uint
+	// Lengths maps every indexed field to its number of tokens.
+	Lengths map[string]int
+	// Checksum is the sha256 of the file the postings were built from.
+	Checksum string
+	Postings []Posting
+	// Links are the links of the body to other notes.
+	Links []search.Link
+}
+
+func (r *sqlRepository) SearchPostings(terms []string, prefix bool) ([]Posting, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var query string
+	args := make([]interface{}, len(terms))
+	if prefix {
+		cond, condArgs := prefixConds(terms)
+		args = condArgs
+		query = "SELECT article_id, term, field, tf, positions FROM search_index WHERE " + cond
+	} else {
+		for i, t := range terms {
+			args[i] = t
+		}
+		query = "SELECT article_id, term, field, tf, positions FROM search_index WHERE term IN (" + placeholders(len(terms)) + ")"
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	for rows.Next() {
+		var p Posting
+		var positions string
+		if err := rows.Scan(&p.ArticleID, &p.Term, &p.Field, &p.TF, &positions); err != nil {
+			return nil, err
+		}
+		p.Positions = decodePositions(positions)
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (r *sqlRepository) ArticlePostings(id uint) ([]Posting, error) {
+	rows, err := r.db.Query("SELECT article_id, term, field, tf, positions FROM search_index WHERE article_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	for rows.Next() {
+		var p Posting
+		var positions string
+		if err := rows.Scan(&p.ArticleID, &p.Term, &p.Field, &p.TF, &positions); err != nil {
+			return nil, err
+		}
+		p.Positions = decodePositions(positions)
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (r *sqlRepository) TermDocs(terms []string) (map[string]int, error) {
+	docs := make(map[string]int, len(terms))
+	// stay well below the bind variable limit of SQLite
+	const chunk = 500
+	for start := 0; start < len(terms); start += chunk {
+		end := start + chunk
+		if end > len(terms) {
+			end = len(terms)
+		}
+		args := make([]interface{}, end-start)
+		for i, t := range terms[start:end] {
+			args[i] = t
+		}
+		rows, err := r.db.Query(`
+			SELECT term, COUNT(DISTINCT article_id)
+			FROM search_index WHERE term IN (`+placeholders(len(args))+`)
+			GROUP BY term
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var term string
+			var n int
+			if err := rows.Scan(&term, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			docs[term] = n
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func (r *sqlRepository) SearchTerms(prefixes []string) ([]string, error) {
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	cond, args := prefixConds(prefixes)
+	rows, err := r.db.Query("SELECT DISTINCT term FROM search_index WHERE "+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []string
+	for rows.Next() {
+		var term string
+		if err := rows.Scan(&term); err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+func (r *sqlRepository) FrequentTerms(limit int) ([]FacetCount, error) {
+	rows, err := r.db.Query(`
+		SELECT term AS v, COUNT(DISTINCT article_id) AS n
+		FROM search_index
+		GROUP BY term ORDER BY n DESC, v LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []FacetCount
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		terms = append(terms, fc)
+	}
+	return terms, rows.Err()
+}
+
+func (r *sqlRepository) ArticleLinks() ([]models.ArticleLink, error) {
+	rows, err := r.db.Query("SELECT article_id, target, wiki FROM article_links")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.ArticleLink
+	for rows.Next() {
+		var link models.ArticleLink
+		if err := rows.Scan(&link.ArticleID, &link.Target, &link.Wiki); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (r *sqlRepository) IndexStats() (*search.Stats, error) {
+	var avgTitle, avgTags, avgHeading, avgBody sql.NullFloat64
+	stats := &search.Stats{}
+	err := r.db.QueryRow(`
+		SELECT COUNT(*), AVG(title_length), AVG(tags_length), AVG(heading_length), AVG(body_length)
+		FROM search_documents
+	`).Scan(&stats.Documents, &avgTitle, &avgTags, &avgHeading, &avgBody)
+	if err != nil {
+		return nil, err
+	}
+	stats.AvgLengths = map[string]float64{
+		search.FieldTitle:   avgTitle.Float64,
+		search.FieldTags:    avgTags.Float64,
+		search.FieldHeading: avgHeading.Float64,
+		search.FieldBody:    avgBody.Float64,
+	}
+	return stats, nil
+}
+
+func (r *sqlRepository) IndexLengths(ids []uint) (map[uint]map[string]int, error) {
+	lengths := make(map[uint]map[string]int, len(ids))
+	// stay well below the bind variable limit of SQLite
+	const chunk = 500
+	for start := 0; start < len(ids); start += chunk {
+		end := start + chunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+		}
+		rows, err := r.db.Query(`
+			SELECT article_id, title_length, tags_length, heading_length, body_length
+			FROM search_documents WHERE article_id IN (`+placeholders(len(args))+`)
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uint
+			var title, tags, heading, body int
+			if err := rows.Scan(&id, &title, &tags, &heading, &body); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			lengths[id] = map[string]int{
+				search.FieldTitle:   title,
+				search.FieldTags:    tags,
+				search.FieldHeading: heading,
+				search.FieldBody:    body,
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lengths, nil
+}
+
+func (r *sqlRepository) IndexChecksums() (map[uint]string, error) {
+	rows, err := r.db.Query("SELECT article_id, checksum FROM search_documents")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := map[uint]string{}
+	for rows.Next() {
+		var id uint
+		var sum string
+		if err := rows.Scan(&id, &sum); err != nil {
+			return nil, err
+		}
+		checksums[id] = sum
+	}
+	return checksums, rows.Err()
+}
+
+func (t *sqlTx) IndexArticle(doc *IndexDocument) error {
+	if err := t.DeleteArticleIndex(doc.ArticleID); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec(`
+		INSERT INTO search_documents (article_id, title_length, tags_length, heading_length, body_length, checksum, indexed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, doc.ArticleID, doc.Lengths[search.FieldTitle], doc.Lengths[search.FieldTags], doc.Lengths[search.FieldHeading],
+		doc.Lengths[search.FieldBody], doc.Checksum, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := t.insertLinks(doc.ArticleID, doc.Links); err != nil {
+		return err
+	}
+	if len(doc.Postings) == 0 {
+		return nil
+	}
+
+	stmt, err := t.tx.Prepare("INSERT INTO search_index (term, field, article_id, tf, positions) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range doc.Postings {
+		if _, err := stmt.Exec(p.Term, p.Field, doc.ArticleID, p.TF, encodePositions(p.Positions)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertLinks stores the distinct links of an article.
+func (t *sqlTx) insertLinks(id uint, links []search.Link) error {
+	seen := map[models.ArticleLink]struct{}{}
+	for _, l := range links {
+		row := models.ArticleLink{ArticleID: id, Target: l.Target, Wiki: l.Wiki}
+		if _, dup := seen[row]; dup || len(l.Target) > maxLinkLength {
+			continue
+		}
+		seen[row] = struct{}{}
+		if _, err := t.tx.Exec("INSERT INTO article_links (article_id, target, wiki) VALUES (?, ?, ?)", id, row.Target, row.Wiki); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) DeleteArticleIndex(id uint) error {
+	for _, table := range []string{"search_index", "search_documents", "article_links"} {
+		if _, err := t.tx.Exec("DELETE FROM "+table+" WHERE article_id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper functions
+
+// prefixConds returns the condition matching the terms starting with any of
+// the prefixes. Terms compare as binary strings on both drivers, so a prefix
+// is the range up to the prefix with its last rune incremented, which the
+// primary key serves (LIKE is case-insensitive in SQLite and cannot).
+func prefixConds(prefixes []string) (string, []interface{}) {
+	conds := make([]string, len(prefixes))
+	var args []interface{}
+	for i, p := range prefixes {
+		if end, ok := prefixEnd(p); ok {
+			conds[i] = "(term >= ? AND term < ?)"
+			args = append(args, p, end)
+		} else {
+			conds[i] = "term >= ?"
+			args = append(args, p)
+		}
+	}
+	return strings.Join(conds, " OR "), args
+}
+
+// prefixEnd returns the smallest string greater than every string starting
+// with p; ok is false when there is none.
+func prefixEnd(p string) (end string, ok bool) {
+	runes := []rune(p)
+	for len(runes) > 0 {
+		last := runes[len(runes)-1] + 1
+		if last >= 0xD800 && last <= 0xDFFF {
+			// surrogates are not valid UTF-8
+			last = 0xE000
+		}
+		if last <= utf8.MaxRune {
+			runes[len(runes)-1] = last
+			return string(runes), true
+		}
+		runes = runes[:len(runes)-1]
+	}
+	return "", false
+}
+
+func encodePositions(positions []int) string {
+	parts := make([]string, len(positions))
+	for i, p := range positions {
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, ",")
+}
+
+func decodePositions(s string) []int {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	positions := make([]int, 0, len(parts))
+	for _, part := range parts {
+		if p, err := strconv.Atoi(part); err == nil {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
@@ -0,0 +1,667 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"pkms/backend/models"
+)
+
+const articleColumns = "id, title, path, type, create_date, edit_date, ref_count, pin"
+
+// articleChildTables reference articles.id through an article_id column.
+var articleChildTables = []string{"article_tags", "article_properties", "article_links", "search_index", "search_documents"}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so the queries below can
+// run inside or outside a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlRepository holds the queries shared by every database/sql backend.
+// The MySQL and SQLite repositories embed it and only differ in how the
+// connection is opened and prepared.
+type sqlRepository struct {
+	db     *sql.DB
+	driver string
+}
+
+func (r *sqlRepository) GetArticle(id uint) (*models.Article, error) {
+	return getArticle(r.db, id)
+}
+
+func (r *sqlRepository) FindArticles(filter ArticleFilter) ([]models.Article, error) {
+	if filter.IDs != nil && len(filter.IDs) == 0 {
+		return nil, nil
+	}
+	if chunks := filter.chunks(); chunks != nil {
+		// every chunk holds its own first page; the page is among them
+		var articles []models.Article
+		for _, c := range chunks {
+			found, err := r.FindArticles(c)
+			if err != nil {
+				return nil, err
+			}
+			articles = append(articles, found...)
+		}
+		sort.SliceStable(articles, func(i, j int) bool {
+			return filter.Sort.Compare(&articles[i], &articles[j]) < 0
+		})
+		if filter.Limit > 0 && len(articles) > filter.Limit {
+			articles = articles[:filter.Limit]
+		}
+		return articles, nil
+	}
+	where, args := filter.where()
+	if filter.After != nil {
+		cond, condArgs, err := filter.Sort.after(*filter.After)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	query := "SELECT a.id, a.title, a.path, a.type, a.create_date, a.edit_date, a.ref_count, a.pin FROM articles a"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += filter.Sort.orderBy()
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []models.Article
+	for rows.Next() {
+		article, err := scanArticle(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, *article)
+	}
+	return articles, rows.Err()
+}
+
+func (r *sqlRepository) CountArticles(filter ArticleFilter) (int, error) {
+	if filter.IDs != nil && len(filter.IDs) == 0 {
+		return 0, nil
+	}
+	if chunks := filter.chunks(); chunks != nil {
+		total := 0
+		for _, c := range chunks {
+			n, err := r.CountArticles(c)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+	where, args := filter.where()
+	query := "SELECT COUNT(*) FROM articles a"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	var n int
+	err := r.db.QueryRow(query, args...).Scan(&n)
+	return n, err
+}
+
+func (r *sqlRepository) Facets(filter ArticleFilter) (*Facets, error) {
+	facets := &Facets{
+		Tags:    []FacetCount{},
+		Folders: []FacetCount{},
+		Types:   []FacetCount{},
+		Months:  []FacetCount{},
+	}
+	if filter.IDs != nil && len(filter.IDs) == 0 {
+		return facets, nil
+	}
+	if chunks := filter.chunks(); chunks != nil {
+		// an article is in one chunk only, so the counts add up
+		for _, c := range chunks {
+			part, err := r.Facets(c)
+			if err != nil {
+				return nil, err
+			}
+			facets.Tags = addCounts(facets.Tags, part.Tags)
+			facets.Folders = addCounts(facets.Folders, part.Folders)
+			facets.Types = addCounts(facets.Types, part.Types)
+			facets.Months = addCounts(facets.Months, part.Months)
+		}
+		for _, counts := range [][]FacetCount{facets.Tags, facets.Folders, facets.Types} {
+			sort.Slice(counts, func(i, j int) bool {
+				if counts[i].Count != counts[j].Count {
+					return counts[i].Count > counts[j].Count
+				}
+				return counts[i].Value < counts[j].Value
+			})
+		}
+		sort.Slice(facets.Months, func(i, j int) bool { return facets.Months[i].Value > facets.Months[j].Value })
+		return facets, nil
+	}
+	where, args := filter.where()
+	matching := "SELECT a.id FROM articles a"
+	if len(where) > 0 {
+		matching += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	// INSTR / SUBSTR work the same in MySQL and SQLite; dates come back as
+	// "2006-01-02 ..." text from both
+	queries := []struct {
+		counts *[]FacetCount
+		query  string
+	}{
+		{&facets.Tags, `
+			SELECT t.name AS v, COUNT(*) AS n
+			FROM article_tags at JOIN tags t ON at.tag_id = t.id
+			WHERE at.article_id IN (` + matching + `)
+			GROUP BY t.name ORDER BY n DESC, v`},
+		{&facets.Folders, `
+			SELECT CASE WHEN INSTR(path, '/') > 0 THEN SUBSTR(path, 1, INSTR(path, '/') - 1) ELSE '' END AS v, COUNT(*) AS n
+			FROM articles WHERE id IN (` + matching + `)
+			GROUP BY v ORDER BY n DESC, v`},
+		{&facets.Types, `
+			SELECT type AS v, COUNT(*) AS n
+			FROM articles WHERE id IN (` + matching + `)
+			GROUP BY v ORDER BY n DESC, v`},
+		{&facets.Months, `
+			SELECT SUBSTR(edit_date, 1, 7) AS v, COUNT(*) AS n
+			FROM articles WHERE id IN (` + matching + `)
+			GROUP BY v ORDER BY v DESC`},
+	}
+	for _, q := range queries {
+		rows, err := r.db.Query(q.query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var fc FacetCount
+			if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			*q.counts = append(*q.counts, fc)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return facets, nil
+}
+
+func (r *sqlRepository) ArticleTags(id uint) ([]string, error) {
+	return articleTags(r.db, id)
+}
+
+func (r *sqlRepository) TagsOfArticles(ids []uint) (map[uint][]string, error) {
+	tags := make(map[uint][]string, len(ids))
+	// stay well below the bind variable limit of SQLite
+	const chunk = 500
+	for start := 0; start < len(ids); start += chunk {
+		end := start + chunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+		}
+		rows, err := r.db.Query(`
+			SELECT at.article_id, t.name
+			FROM tags t
+			JOIN article_tags at ON t.id = at.tag_id
+			WHERE at.article_id IN (`+placeholders(len(args))+`)
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uint
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tags[id] = append(tags[id], name)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+func (r *sqlRepository) FindTags(query string) ([]models.Tag, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if query != "" {
+		like := "%" + strings.ToLower(query) + "%"
+		rows, err = r.db.Query("SELECT id, name FROM tags WHERE LOWER(name) LIKE ?", like)
+	} else {
+		rows, err = r.db.Query("SELECT id, name FROM tags")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (r *sqlRepository) ArticleTagLinks() ([]models.ArticleTag, error) {
+	rows, err := r.db.Query("SELECT article_id, tag_id FROM article_tags ORDER BY article_id, tag_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.ArticleTag
+	for rows.Next() {
+		var link models.ArticleTag
+		if err := rows.Scan(&link.ArticleID, &link.TagID); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (r *sqlRepository) CheckIntegrity() (*IntegrityReport, error) {
+	report := &IntegrityReport{Orphans: map[string]int{}}
+	for _, table := range articleChildTables {
+		var count int
+		err := r.db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE article_id NOT IN (SELECT id FROM articles)").Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		report.Orphans[table] = count
+	}
+	err := r.db.QueryRow(`
+		SELECT COUNT(*) FROM tags
+		WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM tags GROUP BY name) AS keep)
+	`).Scan(&report.DuplicateTags)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (r *sqlRepository) RepairIntegrity() (*IntegrityReport, error) {
+	report := &IntegrityReport{Orphans: map[string]int{}}
+	for _, table := range articleChildTables {
+		result, err := r.db.Exec("DELETE FROM " + table + " WHERE article_id NOT IN (SELECT id FROM articles)")
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		report.Orphans[table] = int(n)
+	}
+	// keep the first occurrence of each tag name
+	result, err := r.db.Exec(`
+		DELETE FROM tags
+		WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM tags GROUP BY name) AS keep)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.DuplicateTags = int(n)
+	return report, nil
+}
+
+func (r *sqlRepository) Begin() (Tx, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx: tx}, nil
+}
+
+func (r *sqlRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlRepository) base() *sqlRepository {
+	return r
+}
+
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t *sqlTx) GetArticle(id uint) (*models.Article, error) {
+	return getArticle(t.tx, id)
+}
+
+func (t *sqlTx) ArticleTags(id uint) ([]string, error) {
+	return articleTags(t.tx, id)
+}
+
+func (t *sqlTx) InsertArticle(article *models.Article) (uint, error) {
+	result, err := t.tx.Exec(`
+		INSERT INTO articles (title, path, type, create_date, edit_date, ref_count, pin)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, article.Title, article.Path, article.Type, dbTime(article.CreateDate), dbTime(article.EditDate), article.RefCount, article.Pin)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+func (t *sqlTx) UpdateArticle(article *models.Article) error {
+	_, err := t.tx.Exec(`
+		UPDATE articles
+		SET title = ?, path = ?, type = ?, pin = ?, create_date = ?, edit_date = ?
+		WHERE id = ?
+	`, article.Title, article.Path, article.Type, article.Pin, dbTime(article.CreateDate), dbTime(article.EditDate), article.ID)
+	return err
+}
+
+func (t *sqlTx) DeleteArticle(id uint) error {
+	for _, table := range articleChildTables {
+		if _, err := t.tx.Exec("DELETE FROM "+table+" WHERE article_id = ?", id); err != nil {
+			return err
+		}
+	}
+	result, err := t.tx.Exec("DELETE FROM articles WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (t *sqlTx) SetArticleTags(id uint, tags []string) error {
+	if _, err := t.tx.Exec("DELETE FROM article_tags WHERE article_id = ?", id); err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(tags))
+	for _, tagName := range tags {
+		if _, dup := seen[tagName]; dup {
+			continue
+		}
+		seen[tagName] = struct{}{}
+		tagID, err := ensureTag(t.tx, tagName)
+		if err != nil {
+			return err
+		}
+		if _, err := t.tx.Exec("INSERT INTO article_tags (article_id, tag_id) VALUES (?, ?)", id, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) DeleteAll() error {
+	// children first so the foreign keys never block the delete
+	tables := append(append([]string{}, articleChildTables...), "articles", "tags")
+	for _, table := range tables {
+		if _, err := t.tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) RestoreArticle(article *models.Article) error {
+	_, err := t.tx.Exec(`
+		INSERT INTO articles (id, title, path, type, create_date, edit_date, ref_count, pin)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, article.ID, article.Title, article.Path, article.Type, dbTime(article.CreateDate), dbTime(article.EditDate), article.RefCount, article.Pin)
+	return err
+}
+
+func (t *sqlTx) RestoreTag(tag *models.Tag) error {
+	_, err := t.tx.Exec("INSERT INTO tags (id, name) VALUES (?, ?)", tag.ID, tag.Name)
+	return err
+}
+
+func (t *sqlTx) RestoreArticleTag(link *models.ArticleTag) error {
+	_, err := t.tx.Exec("INSERT INTO article_tags (article_id, tag_id) VALUES (?, ?)", link.ArticleID, link.TagID)
+	return err
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// Helper functions
+
+// where returns the conditions of the filter on articles a.
+func (filter ArticleFilter) where() ([]string, []interface{}) {
+	var args []interface{}
+	var where []string
+
+	if filter.Path != "" {
+		where = append(where, "LOWER(a.path) LIKE LOWER(?)")
+		args = append(args, "%"+filter.Path+"%")
+	}
+	if len(filter.Tags) > 0 {
+		cond := "a.id IN (SELECT at.article_id FROM article_tags at JOIN tags t ON at.tag_id = t.id WHERE t.name IN (" + placeholders(len(filter.Tags)) + ")"
+		for _, t := range filter.Tags {
+			args = append(args, t)
+		}
+		if filter.TagMode == TagsAll {
+			// every tag: the article has as many of them as were asked for
+			cond += " GROUP BY at.article_id HAVING COUNT(DISTINCT t.id) = ?"
+			args = append(args, len(distinct(filter.Tags)))
+		}
+		where = append(where, cond+")")
+	}
+	if len(filter.ExcludeTags) > 0 {
+		where = append(where, "a.id NOT IN (SELECT at.article_id FROM article_tags at JOIN tags t ON at.tag_id = t.id WHERE t.name IN ("+placeholders(len(filter.ExcludeTags))+"))")
+		for _, t := range filter.ExcludeTags {
+			args = append(args, t)
+		}
+	}
+	for _, p := range filter.Properties {
+		cond, condArgs := p.where()
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	for _, d := range []struct {
+		cond string
+		at   time.Time
+	}{
+		{"a.create_date >= ?", filter.CreatedAfter},
+		{"a.create_date < ?", filter.CreatedBefore},
+		{"a.edit_date >= ?", filter.EditedAfter},
+		{"a.edit_date < ?", filter.EditedBefore},
+	} {
+		if !d.at.IsZero() {
+			where = append(where, d.cond)
+			args = append(args, dbTime(d.at))
+		}
+	}
+	if len(filter.IDs) > 0 {
+		where = append(where, "a.id IN ("+placeholders(len(filter.IDs))+")")
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	return where, args
+}
+
+// chunks splits a filter on more ids than fit in one query into filters
+// on 500 ids each; it returns nil when the filter fits.
+func (filter ArticleFilter) chunks() []ArticleFilter {
+	// stay well below the bind variable limit of SQLite
+	const chunk = 500
+	if len(filter.IDs) <= chunk {
+		return nil
+	}
+	var chunks []ArticleFilter
+	for start := 0; start < len(filter.IDs); start += chunk {
+		end := start + chunk
+		if end > len(filter.IDs) {
+			end = len(filter.IDs)
+		}
+		c := filter
+		c.IDs = filter.IDs[start:end]
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+// addCounts adds the counts of more to counts, by value.
+func addCounts(counts, more []FacetCount) []FacetCount {
+	index := make(map[string]int, len(counts))
+	for i, fc := range counts {
+		index[fc.Value] = i
+	}
+	for _, fc := range more {
+		if i, ok := index[fc.Value]; ok {
+			counts[i].Count += fc.Count
+		} else {
+			index[fc.Value] = len(counts)
+			counts = append(counts, fc)
+		}
+	}
+	return counts
+}
+
+// dbTime is the form every article date is stored and compared in: UTC.
+// SQLite keeps times as text with their offset and compares the text, so
+// dates written with different offsets would not sort, filter or group by
+// month correctly.
+func dbTime(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func getArticle(q queryer, id uint) (*models.Article, error) {
+	row := q.QueryRow("SELECT "+articleColumns+" FROM articles WHERE id = ?", id)
+	article, err := scanArticle(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return article, err
+}
+
+func scanArticle(row scanner) (*models.Article, error) {
+	var article models.Article
+	err := row.Scan(
+		&article.ID,
+		&article.Title,
+		&article.Path,
+		&article.Type,
+		&article.CreateDate,
+		&article.EditDate,
+		&article.RefCount,
+		&article.Pin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+func articleTags(q queryer, id uint) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT t.name
+		FROM tags t
+		JOIN article_tags at ON t.id = at.tag_id
+		WHERE at.article_id = ?
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// ensureTag returns the id of the named tag, creating it if needed.
+func ensureTag(q queryer, name string) (int64, error) {
+	var tagID int64
+	err := q.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		result, err := q.Exec("INSERT INTO tags (name) VALUES (?)", name)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+	return tagID, err
+}
+
+func expectAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+// distinct returns values without repetitions.
+func distinct(values []string) []string {
+	seen := map[string]struct{}{}
+	var out []string
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteRepository stores articles in a single local database file, so PKMS
+// can run as one binary without a database server.
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+// NewSQLite opens (or creates) the database file at path. The schema is
+// created by the migrations (see Migrator).
+func NewSQLite(path string) (*SQLiteRepository, error) {
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteRepository{sqlRepository{db: db, driver: "sqlite"}}, nil
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"math"
+)
+
+// BM25F parameters. A hit in the title is worth three hits in the body,
+// tags and headings sit in between.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+var FieldBoosts = map[string]float64{
+	FieldTitle:   3.0,
+	FieldTags:    2.5,
+	FieldHeading: 2.0,
+	FieldBody:    1.0,
+}
+
+// Stats describes the whole index: the number of documents and the average
+// length (in tokens) of every field.
+type Stats struct {
+	Documents  int
+	AvgLengths map[string]float64
+}
+
+// IDF is the inverse document frequency of a term found in df of n
+// documents. It never goes negative, so very common terms still count a
+// little.
+func IDF(n, df int) float64 {
+	return math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// TermScore is the BM25F score of one term in one document, given its
+// frequency and the length of every field. Frequencies are normalized per
+// field, boosted and summed before saturation, so a term repeated in the
+// body can not outweigh a title hit.
+func TermScore(idf float64, tf, lengths map[string]int, stats *Stats) float64 {
+	weighted := 0.0
+	for field, n := range tf {
+		if n == 0 {
+			continue
+		}
+		norm := 1.0
+		if avg := stats.AvgLengths[field]; avg > 0 {
+			norm = 1 - b + b*float64(lengths[field])/avg
+		}
+		weighted += FieldBoosts[field] * float64(n) / norm
+	}
+	if weighted == 0 {
+		return 0
+	}
+	return idf * weighted * (k1 + 1) / (weighted + k1)
+}
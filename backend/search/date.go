@@ -0,0 +1,59 @@
+package search
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDate = errors.New("expects a date like 2024, 2024-01, 2024-01-31, today, yesterday or 7d")
+
+// ParseDate reads an absolute date (2024, 2024-01 or 2024-01-31) or a
+// relative one counted back from today (today, yesterday, 3d, 2w, 6m or
+// 1y), in local time. It returns the [from, to) range of the year, month
+// or day it names.
+func ParseDate(value string, now time.Time) (from, to time.Time, err error) {
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if from, err := time.ParseInLocation(layout.format, value, time.Local); err == nil {
+			return from, from.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+
+	now = now.In(time.Local)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch value {
+	case "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	}
+	if len(value) < 2 {
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	switch value[len(value)-1] {
+	case 'd':
+		from = today.AddDate(0, 0, -n)
+	case 'w':
+		from = today.AddDate(0, 0, -7*n)
+	case 'm':
+		from = today.AddDate(0, -n, 0)
+	case 'y':
+		from = today.AddDate(-n, 0, 0)
+	default:
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	return from, from.AddDate(0, 0, 1), nil
+}
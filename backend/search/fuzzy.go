@@ -0,0 +1,71 @@
+package search
+
+// MaxEdits is the number of typos tolerated in a term: none for short
+// terms, where one edit already gives another common word, one from four
+// characters and two from eight.
+func MaxEdits(term string) int {
+	n := 0
+	for _, r := range term {
+		if IsCJK(r) {
+			return 0
+		}
+		n++
+	}
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// EditDistance returns the number of insertions, deletions, substitutions
+// and transpositions of adjacent characters turning a into b, and false
+// when it is larger than max.
+func EditDistance(a, b string, max int) (int, bool) {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return 0, false
+	}
+
+	// three rows of the optimal string alignment matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d := prev[j-1] + cost
+			if v := prev[j] + 1; v < d {
+				d = v
+			}
+			if v := cur[j-1] + 1; v < d {
+				d = v
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				if v := prev2[j-2] + 1; v < d {
+					d = v
+				}
+			}
+			cur[j] = d
+			if d < best {
+				best = d
+			}
+		}
+		if best > max {
+			return 0, false
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	d := prev[len(rb)]
+	return d, d <= max
+}
@@ -0,0 +1,188 @@
+package search
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Heading is an ATX heading ("# Title", "## Part") of a markdown body.
+// Offset is the byte offset of its line.
+type Heading struct {
+	Level  int
+	Text   string
+	Offset int
+}
+
+// ParseHeadings returns the ATX headings of a markdown body, skipping
+// fenced code blocks.
+func ParseHeadings(body string) []Heading {
+	var headings []Heading
+	proseLines(body, func(line string, offset int) {
+		if level, text, ok := headingText(strings.TrimSpace(line)); ok {
+			headings = append(headings, Heading{Level: level, Text: text, Offset: offset})
+		}
+	})
+	return headings
+}
+
+// Headings returns the text of the headings of a markdown body.
+func Headings(body string) []string {
+	var texts []string
+	for _, h := range ParseHeadings(body) {
+		texts = append(texts, h.Text)
+	}
+	return texts
+}
+
+// HeadingPath returns the headings of the section holding offset, from the
+// outermost one down ("Setup", "Install").
+func HeadingPath(headings []Heading, offset int) []string {
+	var path []Heading
+	for _, h := range headings {
+		if h.Offset > offset {
+			break
+		}
+		for len(path) > 0 && path[len(path)-1].Level >= h.Level {
+			path = path[:len(path)-1]
+		}
+		path = append(path, h)
+	}
+	texts := make([]string, len(path))
+	for i, h := range path {
+		texts[i] = h.Text
+	}
+	return texts
+}
+
+// Link is a link of a markdown body to another note: the name of a
+// [[wiki link]] or the path of a [text](path.md) link as written, without
+// its #section. Start and End are the byte offsets of Target in the body.
+type Link struct {
+	Target string
+	Wiki   bool
+	Start  int
+	End    int
+}
+
+// markdownLink matches the destination of an inline link, in <> or not.
+var markdownLink = regexp.MustCompile(`\]\(\s*(?:<([^>]*)>|([^)\s]+))`)
+
+// ParseLinks returns the links of a markdown body to other notes: wiki
+// links and links to relative .md files. Code is skipped.
+func ParseLinks(body string) []Link {
+	var links []Link
+	proseLines(body, func(line string, offset int) {
+		line = blankCode(line)
+		// 1. [[name]], [[name#section|label]]
+		for i := 0; ; {
+			open := strings.Index(line[i:], "[[")
+			if open < 0 {
+				break
+			}
+			open += i + 2
+			end := strings.Index(line[open:], "]]")
+			if end < 0 {
+				break
+			}
+			end += open
+			name := line[open:end]
+			if cut := strings.IndexAny(name, "|#"); cut >= 0 {
+				name = name[:cut]
+			}
+			trimmed := strings.TrimSpace(name)
+			if trimmed != "" {
+				start := open + strings.Index(name, trimmed)
+				links = append(links, Link{Target: trimmed, Wiki: true, Start: offset + start, End: offset + start + len(trimmed)})
+			}
+			i = end + 2
+		}
+		// 2. [text](path.md#section)
+		for _, m := range markdownLink.FindAllStringSubmatchIndex(line, -1) {
+			start, end := m[2], m[3]
+			if start < 0 {
+				start, end = m[4], m[5]
+			}
+			target := line[start:end]
+			if cut := strings.IndexAny(target, "#?"); cut >= 0 {
+				target = target[:cut]
+			}
+			if !isNotePath(target) {
+				continue
+			}
+			links = append(links, Link{Target: target, Start: offset + start, End: offset + start + len(target)})
+		}
+	})
+	sort.Slice(links, func(i, j int) bool { return links[i].Start < links[j].Start })
+	return links
+}
+
+// Helper functions
+
+// proseLines calls fn with every line of body outside fenced code blocks
+// and its byte offset.
+func proseLines(body string, fn func(line string, offset int)) {
+	fence := ""
+	offset := 0
+	for _, line := range strings.SplitAfter(body, "\n") {
+		start := offset
+		offset += len(line)
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		fn(line, start)
+	}
+}
+
+// blankCode replaces the `code spans` of a line with spaces, keeping the
+// offsets of the rest.
+func blankCode(line string) string {
+	if !strings.Contains(line, "`") {
+		return line
+	}
+	b := []byte(line)
+	inCode := false
+	for i, c := range b {
+		if c == '`' {
+			inCode = !inCode
+		}
+		if inCode || c == '`' {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// isNotePath tells whether a link destination is a relative markdown file.
+func isNotePath(target string) bool {
+	if !strings.HasSuffix(strings.ToLower(target), ".md") {
+		return false
+	}
+	return !strings.Contains(target, "://") && !strings.HasPrefix(strings.ToLower(target), "mailto:")
+}
+
+// headingText returns the level and text of an ATX heading line.
+func headingText(line string) (int, string, bool) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, "", false
+	}
+	// optional closing sequence: "## Title ##"
+	text := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#"))
+	return level, text, text != ""
+}
@@ -0,0 +1,145 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Suggestion kinds.
+const (
+	SuggestTitle  = "title"
+	SuggestTag    = "tag"
+	SuggestFolder = "folder"
+	SuggestTerm   = "term"
+)
+
+// Suggestion is a completion offered for a prefix.
+type Suggestion struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+	// ArticleID is the article of a title.
+	ArticleID uint `json:"article_id,omitempty"`
+	// Count is the number of articles behind a tag, folder or term.
+	Count int `json:"count,omitempty"`
+	// Score ranks the suggestions, most popular first.
+	Score float64 `json:"score"`
+}
+
+// maxKeyLength caps the folded text kept per key, in bytes; longer
+// prefixes are cut to it before the lookup.
+const maxKeyLength = 64
+
+// PrefixIndex finds suggestions whose folded text, or a word of it,
+// starts with a prefix. It is immutable once built, so lookups need no
+// locking.
+type PrefixIndex struct {
+	items []Suggestion
+	// keys are sorted by text, one per word start of every item.
+	keys []prefixKey
+}
+
+type prefixKey struct {
+	text string
+	item int
+}
+
+// NewPrefixIndex indexes items under every word start of their text (every
+// character in CJK text): a title "Trip to Kyoto" is found by "tri", "kyo"
+// and "to k". Terms are only found by their start.
+func NewPrefixIndex(items []Suggestion) *PrefixIndex {
+	x := &PrefixIndex{items: items}
+	for i, item := range items {
+		if item.Kind == SuggestTerm {
+			// a term is one word (a bigram in CJK text)
+			x.keys = append(x.keys, prefixKey{text: foldKey(item.Text), item: i})
+			continue
+		}
+		starts := map[int]struct{}{}
+		for _, t := range Tokenize(item.Text) {
+			if _, ok := starts[t.Start]; ok {
+				continue
+			}
+			starts[t.Start] = struct{}{}
+			x.keys = append(x.keys, prefixKey{text: foldKey(item.Text[t.Start:]), item: i})
+		}
+	}
+	sort.Slice(x.keys, func(i, j int) bool { return x.keys[i].text < x.keys[j].text })
+	return x
+}
+
+// Len returns the number of suggestions indexed.
+func (x *PrefixIndex) Len() int {
+	return len(x.items)
+}
+
+// Find returns at most max suggestions starting with prefix, highest
+// score first. An empty kinds allows every kind.
+func (x *PrefixIndex) Find(prefix string, kinds []string, max int) []Suggestion {
+	p := foldKey(strings.TrimLeft(prefix, " \t"))
+	if p == "" {
+		return []Suggestion{}
+	}
+
+	// 1. 二分搜尋 prefix 的範圍
+	seen := map[int]struct{}{}
+	var found []int
+	for k := sort.Search(len(x.keys), func(i int) bool { return x.keys[i].text >= p }); k < len(x.keys) && strings.HasPrefix(x.keys[k].text, p); k++ {
+		i := x.keys[k].item
+		if _, ok := seen[i]; ok || !hasKind(kinds, x.items[i].Kind) {
+			continue
+		}
+		seen[i] = struct{}{}
+		found = append(found, i)
+	}
+
+	// 2. 依 score 排序，同分時短的在前
+	sort.Slice(found, func(a, b int) bool {
+		ia, ib := x.items[found[a]], x.items[found[b]]
+		if ia.Score != ib.Score {
+			return ia.Score > ib.Score
+		}
+		if len(ia.Text) != len(ib.Text) {
+			return len(ia.Text) < len(ib.Text)
+		}
+		return ia.Text < ib.Text
+	})
+	if len(found) > max {
+		found = found[:max]
+	}
+	suggestions := make([]Suggestion, len(found))
+	for n, i := range found {
+		suggestions[n] = x.items[i]
+	}
+	return suggestions
+}
+
+// Helper functions
+
+// foldKey folds s and cuts it to maxKeyLength bytes on a rune boundary.
+func foldKey(s string) string {
+	if len(s) > 4*maxKeyLength {
+		s = s[:4*maxKeyLength]
+	}
+	key := Fold(s)
+	if len(key) <= maxKeyLength {
+		return key
+	}
+	cut := maxKeyLength
+	for cut > 0 && !utf8.RuneStart(key[cut]) {
+		cut--
+	}
+	return key[:cut]
+}
+
+func hasKind(kinds []string, kind string) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,456 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxQueryTerms caps the number of terms a query may search for.
+const MaxQueryTerms = 64
+
+// Query fields besides the indexed text fields.
+const (
+	FieldTag     = "tag"
+	FieldPath    = "path"
+	FieldType    = "type"
+	FieldCreated = "created"
+	FieldEdited  = "edited"
+)
+
+// queryFields maps the field names accepted before a ':' to what they
+// match. Text fields search the index, the others filter articles.
+var queryFields = map[string]string{
+	"title":   FieldTitle,
+	"heading": FieldHeading,
+	"body":    FieldBody,
+	"tag":     FieldTag,
+	"tags":    FieldTag,
+	"path":    FieldPath,
+	"type":    FieldType,
+	"created": FieldCreated,
+	"edited":  FieldEdited,
+}
+
+// Node is a parsed query: an *And, *Or, *Not or *Clause.
+type Node interface {
+	node()
+}
+
+// And matches articles matching every node.
+type And struct {
+	Nodes []Node
+}
+
+// Or matches articles matching any node.
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches articles not matching Node.
+type Not struct {
+	Node Node
+}
+
+// Clause is a single condition of a query.
+type Clause struct {
+	// Field is "" for text searched in every indexed field, one of the
+	// indexed fields, or one of the filter fields.
+	Field string
+	// Value is the text as written, without quotes and operator.
+	Value string
+	// Terms are the tokens of Value for text fields, in order.
+	Terms []string
+	// Phrase is set when Terms must occur next to each other.
+	Phrase bool
+	// Op, From and To describe a date condition: Op is one of = < <= > >=
+	// and [From, To) is the day, month or year Value names (see ParseDate).
+	Op       string
+	From, To time.Time
+}
+
+func (*And) node()    {}
+func (*Or) node()     {}
+func (*Not) node()    {}
+func (*Clause) node() {}
+
+// IsText tells whether the clause searches the index.
+func (c *Clause) IsText() bool {
+	switch c.Field {
+	case "", FieldTitle, FieldHeading, FieldBody:
+		return true
+	}
+	return false
+}
+
+// ParseError describes a malformed query. Column counts characters from 1.
+type ParseError struct {
+	Column int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid query: %s (column %d)", e.Msg, e.Column)
+}
+
+// ParseQuery parses the search bar syntax:
+//
+//	cpu benchmark          both words (AND is implied, and may be written)
+//	"exact phrase"         words next to each other
+//	-draft                 articles without the word
+//	tag:food OR tag:snack  either side; OR binds tighter than AND
+//	(a OR b) -c            grouping
+//	title:cpu              word in one field (title, heading, body)
+//	tag:food path:Snack type:markdown
+//	edited:>2024-01-01     created / edited with = < <= > >= and a
+//	                       date (see ParseDate): edited:>=7d
+//	https://example.com    other words with a colon are plain text
+//
+// Words match every term they start ("gard" finds "garden"), phrases match
+// whole terms. An empty query returns a nil Node.
+func ParseQuery(query string) (Node, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseAnd(nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.terms > MaxQueryTerms {
+		return nil, &ParseError{1, fmt.Sprintf("too many terms (at most %d)", MaxQueryTerms)}
+	}
+	return node, nil
+}
+
+// TextClauses returns the text clauses an article has to match, i.e. the
+// ones not under a Not. These are the clauses that rank results.
+func TextClauses(node Node) []*Clause {
+	var clauses []*Clause
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *And:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *Or:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *Clause:
+			if n.IsText() {
+				clauses = append(clauses, n)
+			}
+		}
+	}
+	walk(node)
+	return clauses
+}
+
+// AllClauses returns every clause of the query.
+func AllClauses(node Node) []*Clause {
+	var clauses []*Clause
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *And:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *Or:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *Not:
+			walk(n.Node)
+		case *Clause:
+			clauses = append(clauses, n)
+		}
+	}
+	walk(node)
+	return clauses
+}
+
+// Helper functions
+
+type queryTokenKind int
+
+const (
+	tokEOF queryTokenKind = iota
+	tokWord
+	tokPhrase
+	tokLParen
+	tokRParen
+	tokMinus
+	tokOr
+	tokAnd
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	text  string
+	start int // rune offsets
+	end   int
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	var tokens []queryToken
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{tokLParen, "(", i, i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{tokRParen, ")", i, i + 1})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &ParseError{i + 1, "missing closing quote"}
+			}
+			tokens = append(tokens, queryToken{tokPhrase, string(runes[i+1 : end]), i, end + 1})
+			i = end + 1
+		case r == '-' && (len(tokens) == 0 || tokens[len(tokens)-1].end < i || tokens[len(tokens)-1].kind == tokLParen):
+			tokens = append(tokens, queryToken{tokMinus, "-", i, i + 1})
+			i++
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()"`, runes[end]) {
+				end++
+			}
+			text := string(runes[i:end])
+			kind := tokWord
+			switch text {
+			case "OR", "|":
+				kind = tokOr
+			case "AND", "&":
+				kind = tokAnd
+			}
+			tokens = append(tokens, queryToken{kind, text, i, end})
+			i = end
+		}
+	}
+	return append(tokens, queryToken{kind: tokEOF, start: len(runes), end: len(runes)}), nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	terms  int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// parseAnd parses terms up to the end of the query, or up to the ')'
+// closing open when it is not nil.
+func (p *queryParser) parseAnd(open *queryToken) (Node, error) {
+	var nodes []Node
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokEOF:
+			if open != nil {
+				return nil, &ParseError{open.start + 1, "missing closing parenthesis"}
+			}
+		case tokRParen:
+			if open == nil {
+				return nil, &ParseError{t.start + 1, "unexpected closing parenthesis"}
+			}
+		case tokAnd:
+			p.next()
+			if len(nodes) == 0 || !startsTerm(p.peek().kind) {
+				return nil, &ParseError{t.start + 1, t.text + " needs a term on both sides"}
+			}
+			continue
+		case tokOr:
+			return nil, &ParseError{t.start + 1, t.text + " needs a term on both sides"}
+		}
+		if t.kind == tokEOF || t.kind == tokRParen {
+			break
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	switch len(nodes) {
+	case 0:
+		if open != nil {
+			return nil, &ParseError{open.start + 1, "empty parentheses"}
+		}
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return &And{Nodes: nodes}, nil
+}
+
+func (p *queryParser) parseOr() (Node, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{node}
+	for p.peek().kind == tokOr {
+		or := p.next()
+		if !startsTerm(p.peek().kind) {
+			return nil, &ParseError{or.start + 1, or.text + " needs a term on both sides"}
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return node, nil
+	}
+	return &Or{Nodes: nodes}, nil
+}
+
+func (p *queryParser) parseUnary() (Node, error) {
+	if p.peek().kind != tokMinus {
+		return p.parsePrimary()
+	}
+	minus := p.next()
+	next := p.peek()
+	if next.start != minus.end || !startsTerm(next.kind) || next.kind == tokMinus {
+		return nil, &ParseError{minus.start + 1, `"-" must be followed directly by a term`}
+	}
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &Not{Node: node}, nil
+}
+
+func (p *queryParser) parsePrimary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		node, err := p.parseAnd(&t)
+		if err != nil {
+			return nil, err
+		}
+		p.next() // ')'
+		return node, nil
+	case tokPhrase:
+		return p.textClause("", t.text, true, t)
+	case tokWord:
+		return p.wordClause(t)
+	}
+	return nil, &ParseError{t.start + 1, "expected a term"}
+}
+
+// wordClause turns a word into a clause, splitting off a "field:" prefix.
+// A prefix that is not a field name is part of the text, so
+// "https://example.com" or "note:to" search for their words.
+func (p *queryParser) wordClause(t queryToken) (Node, error) {
+	colon := strings.IndexByte(t.text, ':')
+	if colon <= 0 {
+		return p.textClause("", t.text, false, t)
+	}
+	name := t.text[:colon]
+	field, ok := queryFields[strings.ToLower(name)]
+	if !ok {
+		return p.textClause("", t.text, false, t)
+	}
+
+	value, phrase := t.text[colon+1:], false
+	if value == "" {
+		// title:"some phrase"
+		if next := p.peek(); next.kind == tokPhrase && next.start == t.end {
+			p.next()
+			value, phrase = next.text, true
+		}
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, &ParseError{t.start + 1, name + ": needs a value"}
+	}
+
+	switch field {
+	case FieldCreated, FieldEdited:
+		return dateClause(field, name, value, t)
+	case FieldTag, FieldPath, FieldType:
+		if op := compareOp(value); op != "" {
+			return nil, &ParseError{t.start + 1, fmt.Sprintf("%s: does not support %q, only created and edited do", name, op)}
+		}
+		return &Clause{Field: field, Value: value}, nil
+	}
+	return p.textClause(field, value, phrase, t)
+}
+
+func (p *queryParser) textClause(field, value string, phrase bool, t queryToken) (Node, error) {
+	var terms []string
+	tokens := Tokenize(value)
+	for i, token := range tokens {
+		// the CJK bigrams already cover a trailing single character
+		if i > 0 && token.Pos == tokens[i-1].Pos {
+			continue
+		}
+		terms = append(terms, token.Term)
+	}
+	if phrase && len(terms) == 0 {
+		return nil, &ParseError{t.start + 1, "phrase has nothing to search for"}
+	}
+	p.terms += len(terms)
+	return &Clause{
+		Field:  field,
+		Value:  value,
+		Terms:  terms,
+		Phrase: phrase || len(terms) > 1,
+	}, nil
+}
+
+func dateClause(field, name, value string, t queryToken) (Node, error) {
+	op := compareOp(value)
+	date := value[len(op):]
+	if op == "" {
+		op = "="
+	}
+	from, to, err := ParseDate(date, time.Now())
+	if err != nil {
+		return nil, &ParseError{t.start + 1, fmt.Sprintf("%s: %v, got %q", name, err, date)}
+	}
+	return &Clause{Field: field, Value: value, Op: op, From: from, To: to}, nil
+}
+
+// compareOp returns the comparison operator value starts with, if any.
+func compareOp(value string) string {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func startsTerm(kind queryTokenKind) bool {
+	switch kind {
+	case tokWord, tokPhrase, tokLParen, tokMinus:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Snippet sizes in characters: the text shown per snippet and how much of
+// it comes before the first hit.
+const (
+	snippetWidth   = 160
+	snippetContext = 40
+)
+
+// Span is a [Start, End) byte range of a text.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Snippet is a piece of a body around one or more hits.
+type Snippet struct {
+	// Text is the piece with runs of white space collapsed; "…" marks
+	// where it was cut.
+	Text string `json:"text"`
+	// Highlights are [start, end) offsets of the hits in Text, counted in
+	// characters (code points).
+	Highlights [][2]int `json:"highlights"`
+	// HTML is Text escaped, with the hits in <mark> elements.
+	HTML string `json:"html"`
+	// HeadingPath lists the headings of the section of the first hit.
+	HeadingPath []string `json:"heading_path"`
+}
+
+// MergeSpans sorts spans and joins the overlapping ones.
+func MergeSpans(spans []Span) []Span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	var merged []Span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.Start <= merged[n-1].End {
+			if s.End > merged[n-1].End {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// Snippets cuts at most max snippets out of body around hits, which must
+// be sorted and not overlap (see MergeSpans). The pieces holding the most
+// hits win; they are returned in the order of the body.
+func Snippets(body string, hits []Span, max int) []Snippet {
+	// 1. 把相近的 hits 分成一組
+	var groups [][]Span
+	for i := 0; i < len(hits); {
+		j := i + 1
+		for j < len(hits) && utf8.RuneCountInString(body[hits[i].Start:hits[j].End]) <= snippetWidth-snippetContext {
+			j++
+		}
+		groups = append(groups, hits[i:j])
+		i = j
+	}
+
+	// 2. 留下 hits 最多的組
+	order := make([]int, len(groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(groups[order[a]]) > len(groups[order[b]]) })
+	if len(order) > max {
+		order = order[:max]
+	}
+	sort.Ints(order)
+
+	headings := ParseHeadings(body)
+	snippets := make([]Snippet, 0, len(order))
+	for _, i := range order {
+		snippet := cutSnippet(body, groups[i])
+		snippet.HeadingPath = HeadingPath(headings, groups[i][0].Start)
+		snippets = append(snippets, snippet)
+	}
+	return snippets
+}
+
+// Helper functions
+
+func cutSnippet(body string, hits []Span) Snippet {
+	// 1. 往前取 snippetContext 個字，盡量從字的開頭切
+	start := hits[0].Start
+	for n := 0; n < snippetContext && start > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(body[:start])
+		start -= size
+	}
+	if start > 0 {
+		if i := strings.IndexFunc(body[start:hits[0].Start], unicode.IsSpace); i >= 0 {
+			start += i
+		}
+	}
+	// 2. 往後補滿 snippetWidth 個字
+	end := start
+	for n := 0; n < snippetWidth && end < len(body); n++ {
+		_, size := utf8.DecodeRuneInString(body[end:])
+		end += size
+	}
+	if last := hits[len(hits)-1].End; end < last {
+		end = last
+	}
+	if end < len(body) {
+		if i := strings.LastIndexFunc(body[hits[len(hits)-1].End:end], unicode.IsSpace); i >= 0 {
+			end = hits[len(hits)-1].End + i
+		}
+	}
+
+	// 3. 壓縮空白並換算 highlight 位置
+	var out []rune
+	var highlights [][2]int
+	if start > 0 {
+		out = append(out, '…')
+	}
+	h, open := 0, -1
+	for i, r := range body[start:end] {
+		offset := start + i
+		if open >= 0 && offset >= hits[h].End {
+			highlights = append(highlights, [2]int{open, len(out)})
+			open = -1
+			h++
+		}
+		if open < 0 && h < len(hits) && offset >= hits[h].Start {
+			open = len(out)
+		}
+		if unicode.IsSpace(r) {
+			if n := len(out); n == 0 || out[n-1] == ' ' || out[n-1] == '…' {
+				continue
+			}
+			r = ' '
+		}
+		out = append(out, r)
+	}
+	if open >= 0 {
+		highlights = append(highlights, [2]int{open, len(out)})
+	}
+	for len(out) > 0 && out[len(out)-1] == ' ' {
+		out = out[:len(out)-1]
+	}
+	// a highlight may end in the trimmed spaces
+	for len(highlights) > 0 {
+		last := &highlights[len(highlights)-1]
+		if last[1] > len(out) {
+			last[1] = len(out)
+		}
+		if last[0] < last[1] {
+			break
+		}
+		highlights = highlights[:len(highlights)-1]
+	}
+	if end < len(body) {
+		out = append(out, '…')
+	}
+
+	// 4. <mark> markup
+	var b strings.Builder
+	prev := 0
+	for _, hl := range highlights {
+		b.WriteString(html.EscapeString(string(out[prev:hl[0]])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(out[hl[0]:hl[1]])))
+		b.WriteString("</mark>")
+		prev = hl[1]
+	}
+	b.WriteString(html.EscapeString(string(out[prev:])))
+
+	return Snippet{Text: string(out), Highlights: highlights, HTML: b.String()}
+}
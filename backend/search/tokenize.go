@@ -0,0 +1,222 @@
+// Package search holds the text analysis shared by the index writer and the
+// query side, so both always agree on what a term is.
+package search
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxTermLength is the longest term (in runes) the index stores; longer
+// runs of letters are usually hashes or base64 and are skipped.
+const MaxTermLength = 100
+
+// Indexed fields of an article.
+const (
+	FieldTitle   = "title"
+	FieldTags    = "tags"
+	FieldHeading = "heading"
+	FieldBody    = "body"
+)
+
+// Fields lists the indexed fields.
+var Fields = []string{FieldTitle, FieldTags, FieldHeading, FieldBody}
+
+// Token is one term of a text. Pos counts positions from 0 and Start/End
+// are byte offsets of the original text. The last character of a CJK run
+// is also emitted alone, at the position of the last bigram.
+type Token struct {
+	Term  string
+	Pos   int
+	Start int
+	End   int
+}
+
+// Tokenize splits text into terms. Runes are folded first (see fold) so
+// "WiFi", "wifi" and "ｗｉｆｉ", or "café" and "cafe", give the same term, then
+// runs of letters and digits become one term each, except for CJK text
+// which has no spaces between words: its runs are split into overlapping
+// bigrams ("台北車站" gives 台北, 北車, 車站 and 站), so any word of two
+// or more characters is found as a phrase of bigrams.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	pos := 0
+	emit := func(term string, start, end int) {
+		tokens = append(tokens, Token{Term: term, Pos: pos, Start: start, End: end})
+		pos++
+	}
+
+	var word []rune
+	wordStart, wordEnd := -1, -1
+	flushWord := func() {
+		if wordStart < 0 {
+			return
+		}
+		if len(word) <= MaxTermLength {
+			emit(string(word), wordStart, wordEnd)
+		}
+		word, wordStart = word[:0], -1
+	}
+
+	var run []cjkRune
+	flushRun := func() {
+		switch len(run) {
+		case 0:
+			return
+		case 1:
+			emit(string(run[0].r), run[0].start, run[0].end)
+		default:
+			for i := 0; i+1 < len(run); i++ {
+				emit(string([]rune{run[i].r, run[i+1].r}), run[i].start, run[i+1].end)
+			}
+			last := run[len(run)-1]
+			tokens = append(tokens, Token{Term: string(last.r), Pos: pos - 1, Start: last.start, End: last.end})
+		}
+		run = run[:0]
+	}
+
+	var folded []rune
+	for i, r := range text {
+		end := i + utf8.RuneLen(r)
+		if r == utf8.RuneError {
+			end = i + 1
+		}
+		folded = fold(r, folded[:0])
+		for _, c := range folded {
+			switch {
+			case IsCJK(c):
+				flushWord()
+				run = append(run, cjkRune{c, i, end})
+			case unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.Is(unicode.Mn, c):
+				flushRun()
+				if wordStart < 0 {
+					wordStart = i
+				}
+				word = append(word, c)
+				wordEnd = end
+			default:
+				flushWord()
+				flushRun()
+			}
+		}
+	}
+	flushWord()
+	flushRun()
+	return tokens
+}
+
+// Positions groups the token positions by term.
+func Positions(tokens []Token) map[string][]int {
+	positions := map[string][]int{}
+	for _, t := range tokens {
+		positions[t.Term] = append(positions[t.Term], t.Pos)
+	}
+	return positions
+}
+
+// Fold returns s with every rune folded like the terms of Tokenize; other
+// characters are kept.
+func Fold(s string) string {
+	var out, buf []rune
+	for _, r := range s {
+		buf = fold(r, buf[:0])
+		out = append(out, buf...)
+	}
+	return string(out)
+}
+
+// IsCJK tells whether r belongs to a script written without spaces
+// between words (Hangul is split the same way, like most CJK analyzers).
+func IsCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r == 'ー' // katakana prolonged sound mark
+}
+
+// Helper functions
+
+type cjkRune struct {
+	r          rune
+	start, end int
+}
+
+// simplified maps traditional Chinese characters to simplified ones. A
+// pair whose simplified form is itself a key is rejected: folding would
+// then swap or chain characters instead of merging them.
+var simplified = func() map[rune]rune {
+	runes := []rune(traditionalSimplified)
+	m := make(map[rune]rune, len(runes)/2)
+	for i := 0; i+1 < len(runes); i += 2 {
+		m[runes[i]] = runes[i+1]
+	}
+	for from, to := range m {
+		if next, ok := m[to]; ok {
+			panic(fmt.Sprintf("search: variant %c→%c is folded again to %c", from, to, next))
+		}
+	}
+	return m
+}()
+
+// fold appends the normalized form of r to buf: compatibility characters
+// are replaced (full-width latin and digits become ASCII, half-width
+// katakana full-width), accents are dropped, letters are case folded and
+// traditional Chinese characters become simplified ones.
+func fold(r rune, buf []rune) []rune {
+	if r < utf8.RuneSelf {
+		return append(buf, unicode.ToLower(r))
+	}
+	if isDiacritic(r) {
+		return buf
+	}
+	var b [utf8.UTFMax]byte
+	n := utf8.EncodeRune(b[:], r)
+	if norm.NFKD.IsNormal(b[:n]) {
+		return foldRune(r, buf)
+	}
+	// NFKD splits "é" into "e" and a combining accent; what is left is
+	// composed again so Hangul syllables stay whole.
+	var kept []byte
+	for _, c := range string(norm.NFKD.Bytes(b[:n])) {
+		if !isDiacritic(c) {
+			kept = utf8.AppendRune(kept, c)
+		}
+	}
+	for _, c := range string(norm.NFC.Bytes(kept)) {
+		buf = foldRune(c, buf)
+	}
+	return buf
+}
+
+func foldRune(r rune, buf []rune) []rune {
+	// lower(upper(r)) also folds Σ/ς/σ and the Kelvin sign
+	r = unicode.ToLower(unicode.ToUpper(r))
+	if s, ok := letterFolds[r]; ok {
+		return append(buf, []rune(s)...)
+	}
+	if s, ok := simplified[r]; ok {
+		return append(buf, s)
+	}
+	return append(buf, r)
+}
+
+// letterFolds spells out letters that do not decompose into a base letter
+// and an accent.
+var letterFolds = map[rune]string{
+	'ß': "ss",
+	'æ': "ae",
+	'œ': "oe",
+	'ø': "o",
+	'ł': "l",
+	'đ': "d",
+	'ð': "d",
+	'þ': "th",
+	'ı': "i",
+}
+
+// isDiacritic tells whether r is a combining accent of the Latin, Greek
+// and Cyrillic scripts. Marks of other scripts carry meaning and are kept.
+func isDiacritic(r rune) bool {
+	return r >= 0x300 && r <= 0x36f
+}
@@ -1,68 +1,60 @@
 package services
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"pkms/backend/config"
+	"pkms/backend/frontmatter"
+	"pkms/backend/models"
+	"pkms/backend/repository"
 )
 
 var (
 	ErrArticleNotFound = errors.New("article not found")
 )
 
-type Article struct {
-	ID         uint      `json:"id"`
-	Title      string    `json:"title"`
-	Path       string    `json:"path"`
-	Type       string    `json:"type"`
-	CreateDate time.Time `json:"create_date"`
-	EditDate   time.Time `json:"edit_date"`
-	RefCount   int       `json:"ref_count"`
-	Pin        bool      `json:"pin"`
-}
+type Article = models.Article
 
 type ArticleService struct {
-	db *sql.DB
+	repo    repository.Repository
+	watcher *Watcher
+	suggest *SuggestService
+}
+
+func NewArticleService(repo repository.Repository) *ArticleService {
+	return &ArticleService{repo: repo}
 }
 
-func NewArticleService(db *sql.DB) *ArticleService {
-	return &ArticleService{db: db}
+// SetWatcher makes the service announce its own file writes so the watcher
+// does not sync them a second time.
+func (s *ArticleService) SetWatcher(w *Watcher) {
+	s.watcher = w
+}
+
+// SetSuggestService makes the service refresh the search suggestions after
+// every change.
+func (s *ArticleService) SetSuggestService(suggest *SuggestService) {
+	s.suggest = suggest
 }
 
 // GetArticleByID retrieves an article by its ID
 func (s *ArticleService) GetArticleByID(id uint) (*Article, error) {
-	query := `
-		SELECT id, title, path, type, create_date, edit_date, ref_count, pin
-		FROM articles 
-		WHERE id = ?
-	`
-
-	var article Article
-	err := s.db.QueryRow(query, id).Scan(
-		&article.ID,
-		&article.Title,
-		&article.Path,
-		&article.Type,
-		&article.CreateDate,
-		&article.EditDate,
-		&article.RefCount,
-		&article.Pin,
-	)
-
+	article, err := s.repo.GetArticle(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == repository.ErrNotFound {
 			return nil, ErrArticleNotFound
 		}
 		return nil, err
 	}
+	if article.Properties, err = s.repo.ArticleProperties(id); err != nil {
+		return nil, err
+	}
 
-	return &article, nil
+	return article, nil
 }
 
 type CreateArticleInput struct {
@@ -71,6 +63,8 @@ type CreateArticleInput struct {
 	Type  string
 	Desc  string
 	Tags  []string
+	// Properties are custom frontmatter fields.
+	Properties map[string]interface{}
 }
 
 type UpdateArticleInput struct {
@@ -80,6 +74,9 @@ type UpdateArticleInput struct {
 	Pin     *bool    `json:"pin,omitempty"`
 	Tags    []string `json:"tags,omitempty"`
 	Content *string  `json:"content,omitempty"`
+	// Properties are merged into the custom frontmatter fields; null
+	// removes a field.
+	Properties map[string]interface{} `json:"properties,omitempty"`
 }
 
 type CreateArticleResult struct {
@@ -88,121 +85,112 @@ type CreateArticleResult struct {
 }
 
 func (s *ArticleService) CreateArticle(input CreateArticleInput, cfg *config.Config) (*CreateArticleResult, error) {
-	tx, err := s.db.Begin()
+	// 檢查 path，不覆寫已有的文章或檔案
+	if !validArticlePath(input.Path) {
+		return nil, ErrInvalidPath
+	}
+	existing, err := s.repo.FindArticles(repository.ArticleFilter{Path: input.Path})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	if err := checkFreePath(existing, input.Path, nil, cfg); err != nil {
+		return nil, err
+	}
 
-	// Insert to article table
-	now := time.Now()
-	result, err := tx.Exec(`
-		INSERT INTO articles (title, path, type, create_date, edit_date, ref_count, pin)
-		VALUES (?, ?, ?, ?, ?, 0, false)
-	`, input.Title, input.Path, input.Type, now, now)
+	tx, err := s.repo.Begin()
 	if err != nil {
 		return nil, err
 	}
-	articleID, err := result.LastInsertId()
+	defer tx.Rollback()
+	// 檔案在 commit 失敗時還原
+	files := &fileTx{}
+	defer files.Rollback()
+
+	// Insert to article table
+	now := time.Now()
+	articleID, err := tx.InsertArticle(&Article{
+		Title:      input.Title,
+		Path:       input.Path,
+		Type:       input.Type,
+		CreateDate: now,
+		EditDate:   now,
+	})
 	if err != nil {
 		return nil, err
 	}
 	// Insert to article_tags
-	for _, tagName := range input.Tags {
-		var tagID int64
-		// add tag if not exist
-		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tagName).Scan(&tagID)
-		if err == sql.ErrNoRows {
-			result, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", tagName)
-			if err != nil {
-				return nil, err
-			}
-			tagID, err = result.LastInsertId()
-			if err != nil {
-				return nil, err
-			}
-		} else if err != nil {
-			return nil, err
-		}
-		_, err = tx.Exec("INSERT INTO article_tags (article_id, tag_id) VALUES (?, ?)", articleID, tagID)
-		if err != nil {
-			return nil, err
-		}
+	if err := tx.SetArticleTags(articleID, input.Tags); err != nil {
+		return nil, err
 	}
 
 	// Create article file with YAML frontmatter
+	s.watcher.Suppress(input.Path)
 	targetPath := filepath.Join(cfg.SearchPath, input.Path)
-	targetDir := filepath.Dir(targetPath)
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return nil, err
-	}
 
 	// Create YAML frontmatter
-	tagsStr := ""
-	if len(input.Tags) > 0 {
-		tagsStr = "\"" + input.Tags[0] + "\""
-		for i := 1; i < len(input.Tags); i++ {
-			tagsStr += ", \"" + input.Tags[i] + "\""
-		}
+	doc := frontmatter.New(fmt.Sprintf("\n# %s\n\n%s", input.Title, input.Desc))
+	if err := setArticleMeta(doc, input.Title, input.Tags, input.Type); err != nil {
+		return nil, err
 	}
-
-	content := fmt.Sprintf("---\ntitle: '%s'\ntags: [%s]\ntype: '%s'\n---\n\n# %s\n\n%s",
-		input.Title, tagsStr, input.Type, input.Title, input.Desc)
-
-	targetFile, err := os.Create(targetPath)
-	if err != nil {
+	if err := storeProperties(tx, articleID, doc, input.Properties); err != nil {
 		return nil, err
 	}
-	defer targetFile.Close()
-
-	_, err = targetFile.WriteString(content)
+	data, err := writeDocument(files, targetPath, doc)
 	if err != nil {
 		return nil, err
 	}
+	if err := indexArticle(tx, articleID, input.Title, input.Tags, data); err != nil {
+		return nil, err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	files.Commit()
+	s.suggest.Invalidate()
 
 	return &CreateArticleResult{
-		ArticleID: articleID,
+		ArticleID: int64(articleID),
 		Path:      input.Path,
 	}, nil
 }
 
 func (s *ArticleService) DeleteArticle(id int64, cfg *config.Config) error {
-	tx, err := s.db.Begin()
+	tx, err := s.repo.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
 
 	// 1. 取得 path
-	var path string
-	err = tx.QueryRow("SELECT path FROM articles WHERE id = ?", id).Scan(&path)
+	article, err := tx.GetArticle(uint(id))
 	if err != nil {
+		if err == repository.ErrNotFound {
+			return ErrArticleNotFound
+		}
 		return err
 	}
 
 	// 2. 刪除檔案
-	filePath := filepath.Join(cfg.SearchPath, path)
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+	s.watcher.Suppress(article.Path)
+	filePath := filepath.Join(cfg.SearchPath, article.Path)
+	if err := files.Remove(filePath); err != nil {
 		return err
 	}
 
-	// 3. 刪除 article_tags
-	_, err = tx.Exec("DELETE FROM article_tags WHERE article_id = ?", id)
-	if err != nil {
+	// 3. 刪除 article_tags 與 articles
+	if err := tx.DeleteArticle(uint(id)); err != nil {
 		return err
 	}
 
-	// 4. 刪除 articles
-	_, err = tx.Exec("DELETE FROM articles WHERE id = ?", id)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
-
-	return tx.Commit()
+	files.Commit()
+	s.suggest.Invalidate()
+	return nil
 }
 
 func (s *ArticleService) UpdateArticle(id int64, input UpdateArticleInput, cfg *config.Config) error {
@@ -211,142 +199,166 @@ func (s *ArticleService) UpdateArticle(id int64, input UpdateArticleInput, cfg *
 	if err != nil {
 		return err
 	}
+	// 改 path 就當成搬移，一併改寫連到這篇的連結
+	var move *movePlan
+	if input.Path != nil && *input.Path != currentArticle.Path {
+		title := currentArticle.Title
+		if input.Title != nil {
+			title = *input.Title
+		}
+		if move, err = s.planMove(currentArticle, *input.Path, title, cfg); err != nil {
+			return err
+		}
+	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.repo.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
 
 	// 準備最新值
-	title := currentArticle.Title
+	updated := *currentArticle
 	if input.Title != nil {
-		title = *input.Title
+		updated.Title = *input.Title
 	}
-	path := currentArticle.Path
 	if input.Path != nil {
-		path = *input.Path
+		updated.Path = *input.Path
 	}
-	typeValue := currentArticle.Type
 	if input.Type != nil {
-		typeValue = *input.Type
+		updated.Type = *input.Type
 	}
-	pin := currentArticle.Pin
 	if input.Pin != nil {
-		pin = *input.Pin
+		updated.Pin = *input.Pin
 	}
+	updated.EditDate = time.Now()
 
 	// 1. 更新 articles table
-	now := time.Now()
-	_, err = tx.Exec(`
-		UPDATE articles 
-		SET title = ?, path = ?, type = ?, pin = ?, edit_date = ?
-		WHERE id = ?
-	`, title, path, typeValue, pin, now, id)
-	if err != nil {
+	if err := tx.UpdateArticle(&updated); err != nil {
 		return err
 	}
+	if move != nil {
+		if err := s.applyMove(tx, files, move, cfg); err != nil {
+			return err
+		}
+	}
 
 	// 2. tags 有提供才更新
 	var tags []string
 	if input.Tags != nil {
-		// 刪除所有 article_tags
-		_, err = tx.Exec("DELETE FROM article_tags WHERE article_id = ?", id)
-		if err != nil {
+		if err := tx.SetArticleTags(uint(id), input.Tags); err != nil {
 			return err
 		}
-		// 重新插入
-		for _, tagName := range input.Tags {
-			var tagID int64
-			err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tagName).Scan(&tagID)
-			if err == sql.ErrNoRows {
-				result, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", tagName)
-				if err != nil {
-					return err
-				}
-				tagID, err = result.LastInsertId()
-				if err != nil {
-					return err
-				}
-			} else if err != nil {
-				return err
-			}
-			_, err = tx.Exec("INSERT INTO article_tags (article_id, tag_id) VALUES (?, ?)", id, tagID)
-			if err != nil {
-				return err
-			}
-		}
 		tags = input.Tags
 	} else {
 		// 沒有提供 tags，查詢現有 tags
-		rows, err := tx.Query(`
-			SELECT t.name 
-			FROM tags t 
-			JOIN article_tags at ON t.id = at.tag_id 
-			WHERE at.article_id = ?
-		`, id)
+		tags, err = tx.ArticleTags(uint(id))
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var tagName string
-			if err := rows.Scan(&tagName); err != nil {
-				return err
-			}
-			tags = append(tags, tagName)
-		}
 	}
 
 	// 3. 只要有 title、type、tags、content、path 任一有提供就重寫檔案
-	needUpdateFile := input.Title != nil || input.Type != nil || input.Tags != nil || input.Content != nil || input.Path != nil
+	needUpdateFile := input.Title != nil || input.Type != nil || input.Tags != nil || input.Content != nil || input.Path != nil || input.Properties != nil
 	if needUpdateFile {
-		targetPath := filepath.Join(cfg.SearchPath, path)
-		targetDir := filepath.Dir(targetPath)
-		if err := os.MkdirAll(targetDir, 0755); err != nil {
-			return err
-		}
-
-		// tags YAML 字串
-		tagsStr := ""
-		if len(tags) > 0 {
-			tagsStr = "\"" + tags[0] + "\""
-			for i := 1; i < len(tags); i++ {
-				tagsStr += ", \"" + tags[i] + "\""
+		s.watcher.Suppress(updated.Path)
+		targetPath := filepath.Join(cfg.SearchPath, updated.Path)
+
+		// 讀現有檔案 (已搬到新 path)，保留 frontmatter 其他欄位
+		doc := frontmatter.New("")
+		if fileContent, err := os.ReadFile(targetPath); err == nil {
+			if doc, err = frontmatter.Parse(fileContent); err != nil {
+				return fmt.Errorf("%s: %w", currentArticle.Path, err)
 			}
+		} else if !os.IsNotExist(err) {
+			return err
 		}
-
-		// content
-		content := ""
 		if input.Content != nil {
-			content = *input.Content
-		} else {
-			// 讀現有檔案內容，去除 frontmatter
-			currentFilePath := filepath.Join(cfg.SearchPath, currentArticle.Path)
-			if fileContent, err := os.ReadFile(currentFilePath); err == nil {
-				str := string(fileContent)
-				if idx := strings.Index(str, "\n---\n"); idx != -1 {
-					content = str[idx+5:]
-				} else {
-					content = str
-				}
-			}
+			doc.Body = "\n" + *input.Content
 		}
-
-		fileContent := fmt.Sprintf("---\ntitle: '%s'\ntags: [%s]\ntype: '%s'\n---\n\n%s",
-			title, tagsStr, typeValue, content)
-
-		targetFile, err := os.Create(targetPath)
-		if err != nil {
+		if err := setArticleMeta(doc, updated.Title, tags, updated.Type); err != nil {
 			return err
 		}
-		defer targetFile.Close()
-
-		_, err = targetFile.WriteString(fileContent)
+		if err := touchEditDate(doc, updated.EditDate); err != nil {
+			return err
+		}
+		if err := storeProperties(tx, uint(id), doc, input.Properties); err != nil {
+			return err
+		}
+		data, err := writeDocument(files, targetPath, doc)
 		if err != nil {
 			return err
 		}
+		if err := indexArticle(tx, uint(id), updated.Title, tags, data); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	files.Commit()
+	if move != nil {
+		s.finishMove(move, cfg)
+	} else {
+		s.suggest.Invalidate()
 	}
+	return nil
+}
 
-	return tx.Commit()
+// storeProperties merges properties into the frontmatter and saves the
+// resulting custom fields of the file.
+func storeProperties(tx repository.Tx, id uint, doc *frontmatter.Document, properties map[string]interface{}) error {
+	if err := setProperties(doc, properties); err != nil {
+		return err
+	}
+	custom, err := customProperties(doc)
+	if err != nil {
+		return err
+	}
+	return tx.SetArticleProperties(id, custom)
+}
+
+// Helper functions
+
+// setArticleMeta writes the fields the database keeps for an article into
+// the frontmatter.
+func setArticleMeta(doc *frontmatter.Document, title string, tags []string, typ string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	if err := doc.Set("title", title); err != nil {
+		return err
+	}
+	if err := doc.Set("tags", tags); err != nil {
+		return err
+	}
+	return doc.Set("type", typ)
+}
+
+// touchEditDate updates an edit_date already present in the frontmatter,
+// keeping its format, so the file does not hold an older date than the
+// database. Like every frontmatter date it is written in local time.
+func touchEditDate(doc *frontmatter.Document, t time.Time) error {
+	value, ok, err := doc.String("edit_date")
+	if err != nil || !ok {
+		return err
+	}
+	layout := "2006-01-02"
+	if d, err := parseFrontmatterDate(value); err == nil && d != nil {
+		layout = d.layout
+	}
+	return doc.Set("edit_date", t.Local().Format(layout))
+}
+
+// writeDocument writes doc to path within files and returns the written
+// bytes.
+func writeDocument(files *fileTx, path string, doc *frontmatter.Document) ([]byte, error) {
+	data, err := doc.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return data, files.Write(path, data)
 }
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pkms/backend/config"
+	"pkms/backend/models"
+	"pkms/backend/repository"
+)
+
+const (
+	// BackupFormatVersion is bumped whenever the archive layout changes.
+	// Version 2 added db/article_properties.json.
+	BackupFormatVersion = 2
+
+	backupManifestName = "manifest.json"
+	backupDBDir        = "db"
+	backupFilesDir     = "articles"
+)
+
+// BackupManifest describes the content of a backup archive. It is written
+// as the last entry of the archive so it can record every other entry.
+type BackupManifest struct {
+	FormatVersion int          `json:"format_version"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Counts        BackupCounts `json:"counts"`
+	// Checksums maps every archive entry name to its sha256.
+	Checksums map[string]string `json:"checksums"`
+}
+
+type BackupCounts struct {
+	Articles    int   `json:"articles"`
+	Tags        int   `json:"tags"`
+	ArticleTags int   `json:"article_tags"`
+	Properties  int   `json:"article_properties"`
+	Files       int   `json:"files"`
+	FileBytes   int64 `json:"file_bytes"`
+}
+
+type BackupService struct {
+	repo repository.Repository
+	root string
+}
+
+func NewBackupService(repo repository.Repository, cfg *config.Config) *BackupService {
+	return &BackupService{repo: repo, root: cfg.SearchPath}
+}
+
+// Create writes a tar.gz archive with a portable dump of the articles, tags,
+// article_tags and article_properties tables plus every file under the articles root.
+//
+//	db/articles.json
+//	db/tags.json
+//	db/article_tags.json
+//	db/article_properties.json
+//	articles/<path>
+//	manifest.json
+func (s *BackupService) Create(w io.Writer) (*BackupManifest, error) {
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(articles, func(i, j int) bool { return articles[i].ID < articles[j].ID })
+	tags, err := s.repo.FindTags("")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	links, err := s.repo.ArticleTagLinks()
+	if err != nil {
+		return nil, err
+	}
+	properties, err := s.repo.ArticlePropertyRows()
+	if err != nil {
+		return nil, err
+	}
+	// keep empty tables as [] instead of null in the dump
+	if articles == nil {
+		articles = []models.Article{}
+	}
+	if tags == nil {
+		tags = []models.Tag{}
+	}
+	if links == nil {
+		links = []models.ArticleTag{}
+	}
+	if properties == nil {
+		properties = []models.ArticleProperty{}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := &BackupManifest{
+		FormatVersion: BackupFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Counts: BackupCounts{
+			Articles:    len(articles),
+			Tags:        len(tags),
+			ArticleTags: len(links),
+			Properties:  len(properties),
+		},
+		Checksums: map[string]string{},
+	}
+
+	// 1. database tables
+	tables := []struct {
+		name string
+		rows interface{}
+	}{
+		{"articles", articles},
+		{"tags", tags},
+		{"article_tags", links},
+		{"article_properties", properties},
+	}
+	for _, table := range tables {
+		data, err := json.MarshalIndent(table.rows, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		name := path.Join(backupDBDir, table.name+".json")
+		if err := writeTarEntry(tw, name, data, manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+		manifest.Checksums[name] = checksum(data)
+	}
+
+	// 2. article files
+	err = filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(backupFilesDir, filepath.ToSlash(rel))
+		if err := writeTarEntry(tw, name, data, info.ModTime()); err != nil {
+			return err
+		}
+		manifest.Checksums[name] = checksum(data)
+		manifest.Counts.Files++
+		manifest.Counts.FileBytes += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. manifest
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarEntry(tw, backupManifestName, data, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Helper functions
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"pkms/backend/utils"
+)
+
+// fileTx groups the article file writes and removals made along with a
+// database transaction. Every change is atomic on its own; Rollback puts
+// the previous files back when the transaction does not commit, so the
+// files and the database change together or not at all.
+type fileTx struct {
+	undo []fileUndo
+}
+
+type fileUndo struct {
+	path string
+	// data is the previous content, nil when the file did not exist.
+	data []byte
+	// dir is the outermost folder created for the file, if any.
+	dir string
+	// from is set when the file was renamed from there.
+	from string
+}
+
+// Write replaces the file at path with data, creating its folder.
+func (f *fileTx) Write(path string, data []byte) error {
+	old, err := readIfExists(path)
+	if err != nil {
+		return err
+	}
+	dir := missingDir(filepath.Dir(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(path, data, 0644); err != nil {
+		// the file is unchanged, only the new folders are left
+		removeDirs(filepath.Dir(path), dir)
+		return err
+	}
+	f.undo = append(f.undo, fileUndo{path: path, data: old, dir: dir})
+	return nil
+}
+
+// Remove deletes the file at path if it exists.
+func (f *fileTx) Remove(path string) error {
+	old, err := readIfExists(path)
+	if err != nil || old == nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	f.undo = append(f.undo, fileUndo{path: path, data: old})
+	return nil
+}
+
+// Rename moves the file at from to path, creating its folder. path must
+// not exist, except as from itself under another case.
+func (f *fileTx) Rename(from, path string) error {
+	dir := missingDir(filepath.Dir(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, path); err != nil {
+		removeDirs(filepath.Dir(path), dir)
+		return err
+	}
+	f.undo = append(f.undo, fileUndo{path: path, dir: dir, from: from})
+	return nil
+}
+
+// Commit keeps the changes; call it once the database committed.
+func (f *fileTx) Commit() {
+	f.undo = nil
+}
+
+// Rollback restores the files changed since the fileTx was created, latest
+// change first. It does nothing after Commit, so it can be deferred.
+func (f *fileTx) Rollback() {
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		u := f.undo[i]
+		var err error
+		if u.from != "" {
+			if err = os.MkdirAll(filepath.Dir(u.from), 0755); err == nil {
+				if err = os.Rename(u.path, u.from); err == nil {
+					removeDirs(filepath.Dir(u.path), u.dir)
+				}
+			}
+		} else if u.data == nil {
+			if err = os.Remove(u.path); err == nil || os.IsNotExist(err) {
+				err = nil
+				removeDirs(filepath.Dir(u.path), u.dir)
+			}
+		} else if err = os.MkdirAll(filepath.Dir(u.path), 0755); err == nil {
+			// the folder may have been removed once empty
+			err = utils.WriteFileAtomic(u.path, u.data, 0644)
+		}
+		if err != nil {
+			log.Printf("failed to restore %s: %v", u.path, err)
+		}
+	}
+	f.undo = nil
+}
+
+// Helper functions
+
+// readIfExists returns the content of the file at path, nil when there is
+// none.
+func readIfExists(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil && data == nil {
+		// an empty file still has to be restored
+		data = []byte{}
+	}
+	return data, err
+}
+
+// missingDir returns the outermost folder of dir that does not exist yet,
+// "" when dir exists.
+func missingDir(dir string) string {
+	missing := ""
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return missing
+		}
+		missing = dir
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return missing
+		}
+		dir = parent
+	}
+}
+
+// removeDirs removes dir and its parents up to top when they are empty.
+func removeDirs(dir, top string) {
+	if top == "" {
+		return
+	}
+	for {
+		if os.Remove(dir) != nil || dir == top {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
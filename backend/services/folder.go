@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pkms/backend/config"
+	"pkms/backend/repository"
+)
+
+var (
+	ErrInvalidFolder  = errors.New("invalid folder path")
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+)
+
+// FolderMoveResult describes a moved folder.
+type FolderMoveResult struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Articles are the new paths of the articles in the folder.
+	Articles []string `json:"articles"`
+	// Updated are the paths of the other articles whose links were
+	// rewritten.
+	Updated []string `json:"updated"`
+}
+
+// CreateFolder creates the folder p below the root, with its parents.
+func (s *ArticleService) CreateFolder(p string, cfg *config.Config) error {
+	if !validFolderPath(p) {
+		return ErrInvalidFolder
+	}
+	target := filepath.Join(cfg.SearchPath, filepath.FromSlash(p))
+	if _, err := os.Stat(target); err == nil {
+		return ErrPathExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+// MoveFolder renames the folder from to to, updating the paths of the
+// articles inside in one transaction and rewriting the links to them.
+func (s *ArticleService) MoveFolder(from, to string, cfg *config.Config) (*FolderMoveResult, error) {
+	// 1. 檢查來源與目的地
+	if !validFolderPath(from) || !validFolderPath(to) || strings.HasPrefix(to, from+"/") {
+		return nil, ErrInvalidFolder
+	}
+	source := filepath.Join(cfg.SearchPath, filepath.FromSlash(from))
+	info, err := os.Stat(source)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	result := &FolderMoveResult{From: from, To: to, Articles: []string{}, Updated: []string{}}
+	if to == from {
+		return result, nil
+	}
+	if existing, err := os.Stat(filepath.Join(cfg.SearchPath, filepath.FromSlash(to))); err == nil {
+		// only the same folder under another case, on a case-insensitive disk
+		if !os.SameFile(info, existing) {
+			return nil, ErrPathExists
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// 2. 資料夾裡的文章
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var moved []Article
+	for _, a := range articles {
+		if strings.HasPrefix(a.Path, to+"/") {
+			return nil, ErrPathExists
+		}
+		if strings.HasPrefix(a.Path, from+"/") {
+			a.Path = to + strings.TrimPrefix(a.Path, from)
+			moved = append(moved, a)
+			result.Articles = append(result.Articles, a.Path)
+		}
+	}
+	sort.Strings(result.Articles)
+	plan, err := s.planLinks(articles, moved, from, to, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 一個 transaction 更新所有 path
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
+
+	if err := plan.updateArticles(tx); err != nil {
+		return nil, err
+	}
+	if err := s.applyMove(tx, files, plan, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	files.Commit()
+	s.finishMove(plan, cfg)
+
+	result.Updated = plan.updated()
+	return result, nil
+}
+
+// DeleteFolder deletes the folder p and returns the number of articles
+// deleted with it. Unless recursive is set the folder must be empty.
+func (s *ArticleService) DeleteFolder(p string, recursive bool, cfg *config.Config) (int, error) {
+	// 1. 檢查資料夾
+	if !validFolderPath(p) {
+		return 0, ErrInvalidFolder
+	}
+	target := filepath.Join(cfg.SearchPath, filepath.FromSlash(p))
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return 0, ErrFolderNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, os.Remove(target)
+	}
+	if !recursive {
+		return 0, ErrFolderNotEmpty
+	}
+
+	// 2. 刪除裡面的文章，檔案先移到隱藏的 trash 以便還原
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return 0, err
+	}
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
+
+	deleted := 0
+	for _, a := range articles {
+		if !strings.HasPrefix(a.Path, p+"/") {
+			continue
+		}
+		s.watcher.Suppress(a.Path)
+		if err := tx.DeleteArticle(a.ID); err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	trash, err := trashPath(target)
+	if err != nil {
+		return 0, err
+	}
+	if err := files.Rename(target, trash); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	files.Commit()
+	if err := os.RemoveAll(trash); err != nil {
+		log.Printf("failed to remove %s: %v", trash, err)
+	}
+	s.suggest.Invalidate()
+	return deleted, nil
+}
+
+// Helper functions
+
+// trashPath returns an unused hidden name next to dir, which the watcher
+// and the sync ignore.
+func trashPath(dir string) (string, error) {
+	trash, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".deleted-*")
+	if err != nil {
+		return "", err
+	}
+	return trash, os.Remove(trash)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pkms/backend/config"
+	"pkms/backend/frontmatter"
+	"pkms/backend/repository"
+	"pkms/backend/search"
+)
+
+// IndexService maintains the inverted index in search_index and answers
+// queries from it, so searching never has to read article files.
+type IndexService struct {
+	repo    repository.Repository
+	root    string
+	suggest *SuggestService
+}
+
+func NewIndexService(repo repository.Repository, cfg *config.Config) *IndexService {
+	return &IndexService{repo: repo, root: cfg.SearchPath}
+}
+
+// SetSuggestService makes Reindex refresh the index terms offered as search
+// suggestions.
+func (s *IndexService) SetSuggestService(suggest *SuggestService) {
+	s.suggest = suggest
+}
+
+// ReindexReport counts what Reindex did.
+type ReindexReport struct {
+	Indexed   int         `json:"indexed"`
+	Unchanged int         `json:"unchanged"`
+	Errors    []SyncError `json:"errors,omitempty"`
+}
+
+// IndexMatch tells how an article matched a query.
+type IndexMatch struct {
+	ArticleID uint
+	// InTitle is set when every text clause matches in the title.
+	InTitle bool
+	// Score is the BM25F relevance of the article for the query.
+	Score float64
+	// Fuzzy is set when a word only matched with typos.
+	Fuzzy bool
+
+	// words maps the matched index terms to the query word they matched,
+	// phrases lists the matched phrases; Snippets highlights both.
+	words   map[string]string
+	phrases [][]string
+}
+
+// Reindex indexes every article whose file changed since it was last
+// indexed, or every article when force is set.
+func (s *IndexService) Reindex(force bool) (*ReindexReport, error) {
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := s.repo.IndexChecksums()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := articleTagNames(s.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReindexReport{}
+	for _, a := range articles {
+		content, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(a.Path)))
+		if err != nil {
+			report.Errors = append(report.Errors, SyncError{Path: a.Path, Err: err.Error()})
+			continue
+		}
+		if !force && checksums[a.ID] == checksum(content) {
+			report.Unchanged++
+			continue
+		}
+		if err := s.index(a.ID, a.Title, tags[a.ID], content); err != nil {
+			report.Errors = append(report.Errors, SyncError{Path: a.Path, Err: err.Error()})
+			continue
+		}
+		report.Indexed++
+	}
+	if report.Indexed > 0 {
+		s.suggest.Invalidate()
+	}
+	return report, nil
+}
+
+func (s *IndexService) index(id uint, title string, tags []string, content []byte) error {
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := indexArticle(tx, id, title, tags, content); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PopularityBonus is added to the text score of a hit: pinned notes and
+// notes referenced often come first among similar matches.
+func PopularityBonus(article *Article) float64 {
+	bonus := 0.5 * math.Log1p(float64(article.RefCount))
+	if article.Pin {
+		bonus += 1
+	}
+	return bonus
+}
+
+// indexArticle rebuilds the index rows of an article from its title, tags
+// and the content of its file.
+func indexArticle(tx repository.Tx, id uint, title string, tags []string, content []byte) error {
+	doc, err := frontmatter.Parse(content)
+	if err != nil {
+		return err
+	}
+	tokens := map[string][]search.Token{
+		search.FieldTitle:   search.Tokenize(title),
+		search.FieldTags:    search.Tokenize(strings.Join(tags, "\n")),
+		search.FieldHeading: search.Tokenize(strings.Join(search.Headings(doc.Body), "\n")),
+		search.FieldBody:    search.Tokenize(doc.Body),
+	}
+
+	entry := &repository.IndexDocument{
+		ArticleID: id,
+		Lengths:   map[string]int{},
+		Checksum:  checksum(content),
+		Links:     search.ParseLinks(doc.Body),
+	}
+	for _, field := range search.Fields {
+		entry.Lengths[field] = len(tokens[field])
+		positions := search.Positions(tokens[field])
+		terms := make([]string, 0, len(positions))
+		for term := range positions {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		for _, term := range terms {
+			entry.Postings = append(entry.Postings, repository.Posting{
+				ArticleID: id,
+				Term:      term,
+				Field:     field,
+				TF:        len(positions[term]),
+				Positions: positions[term],
+			})
+		}
+	}
+	return tx.IndexArticle(entry)
+}
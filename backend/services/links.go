@@ -0,0 +1,64 @@
+package services
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// linkResolver finds the articles that links point to. Paths, names and
+// titles are compared case-insensitively.
+type linkResolver struct {
+	// byPath maps "food/apple pie.md" to its article.
+	byPath map[string]uint
+	// byName maps "food/apple pie" and "apple pie" to the article;
+	// byTitle its title.
+	byName  map[string]uint
+	byTitle map[string]uint
+}
+
+func newLinkResolver(articles []Article) *linkResolver {
+	r := &linkResolver{byPath: map[string]uint{}, byName: map[string]uint{}, byTitle: map[string]uint{}}
+	for _, a := range articles {
+		p := strings.ToLower(a.Path)
+		r.byPath[p] = a.ID
+		name := strings.TrimSuffix(p, ".md")
+		r.byName[name] = a.ID
+		// the first article wins a base name shared by several folders
+		if _, ok := r.byName[path.Base(name)]; !ok {
+			r.byName[path.Base(name)] = a.ID
+		}
+		r.byTitle[strings.ToLower(a.Title)] = a.ID
+	}
+	return r
+}
+
+// resolve returns the article a link written in source points to: a
+// markdown link is relative to the folder of source (or to the root when
+// it starts with "/"), a wiki link names a file or a title.
+func (r *linkResolver) resolve(source, target string, wiki bool) (uint, bool) {
+	if wiki {
+		name := strings.ToLower(strings.TrimSuffix(target, ".md"))
+		if id, ok := r.byName[name]; ok {
+			return id, true
+		}
+		id, ok := r.byTitle[strings.ToLower(target)]
+		return id, ok
+	}
+	id, ok := r.byPath[strings.ToLower(linkPath(source, target))]
+	return id, ok
+}
+
+// Helper functions
+
+// linkPath returns the article path a markdown link written in source
+// points to.
+func linkPath(source, target string) string {
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(path.Clean(target), "/")
+	}
+	return path.Join(path.Dir(source), target)
+}
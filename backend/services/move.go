@@ -0,0 +1,388 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pkms/backend/config"
+	"pkms/backend/frontmatter"
+	"pkms/backend/repository"
+	"pkms/backend/search"
+	"pkms/backend/utils"
+)
+
+var ErrPathExists = errors.New("path already exists")
+
+// MoveResult describes a moved article.
+type MoveResult struct {
+	ID   uint   `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Updated are the paths of the other articles whose links to the
+	// moved one were rewritten.
+	Updated []string `json:"updated"`
+}
+
+// movePlan is a move prepared before the transaction: the file or folder
+// to rename, the articles it carries and the files whose links change, with
+// their new content.
+type movePlan struct {
+	// from and to are the renamed file or folder, relative to the root.
+	from, to string
+	// moved are the moved articles, at their new path.
+	moved []Article
+	// rewrites holds the rewritten files by article id, moved articles
+	// included when their own links change.
+	rewrites map[uint][]byte
+	titles   map[uint]string
+	// paths are the paths of the articles before the move, newPaths after.
+	paths    map[uint]string
+	newPaths map[uint]string
+	tags     map[uint][]string
+}
+
+// MoveArticle renames the file of an article to the path to, removes the
+// folders left empty and rewrites the links of the other articles to it.
+func (s *ArticleService) MoveArticle(id uint, to string, cfg *config.Config) (*MoveResult, error) {
+	article, err := s.GetArticleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	result := &MoveResult{ID: id, From: article.Path, To: to, Updated: []string{}}
+	if to == article.Path {
+		return result, nil
+	}
+	plan, err := s.planMove(article, to, article.Title, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
+
+	if err := plan.updateArticles(tx); err != nil {
+		return nil, err
+	}
+	if err := s.applyMove(tx, files, plan, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	files.Commit()
+	s.finishMove(plan, cfg)
+
+	result.Updated = plan.updated()
+	return result, nil
+}
+
+// planMove checks that article can move to the path to and prepares the
+// links to rewrite, title being the title of the article after the move.
+func (s *ArticleService) planMove(article *Article, to, title string, cfg *config.Config) (*movePlan, error) {
+	// 1. 檢查目的地
+	if !validArticlePath(to) {
+		return nil, ErrInvalidPath
+	}
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFreePath(articles, to, article, cfg); err != nil {
+		return nil, err
+	}
+
+	// 2. 連結
+	moved := *article
+	moved.Path, moved.Title = to, title
+	return s.planLinks(articles, []Article{moved}, article.Path, to, cfg)
+}
+
+// planLinks prepares the move of the file or folder from to to, carrying
+// the moved articles, out of every article: the links to a moved article
+// and the relative links of the moved articles are rewritten unless they
+// still point to the same note.
+func (s *ArticleService) planLinks(articles, moved []Article, from, to string, cfg *config.Config) (*movePlan, error) {
+	plan := &movePlan{
+		from:     from,
+		to:       to,
+		moved:    moved,
+		rewrites: map[uint][]byte{},
+		titles:   map[uint]string{},
+		paths:    map[uint]string{},
+		newPaths: map[uint]string{},
+	}
+	// 1. 移動前後的 resolver
+	after := map[uint]Article{}
+	for _, a := range moved {
+		after[a.ID] = a
+	}
+	afterArticles := make([]Article, len(articles))
+	for i, a := range articles {
+		plan.paths[a.ID] = a.Path
+		if m, ok := after[a.ID]; ok {
+			a = m
+		}
+		plan.titles[a.ID] = a.Title
+		plan.newPaths[a.ID] = a.Path
+		afterArticles[i] = a
+	}
+	before := newLinkResolver(articles)
+	resolver := newLinkResolver(afterArticles)
+
+	// 2. 移動的文章，以及連到它們的文章
+	links, err := s.repo.ArticleLinks()
+	if err != nil {
+		return nil, err
+	}
+	sources := map[uint]bool{}
+	for _, l := range links {
+		if _, ok := after[l.ArticleID]; ok {
+			sources[l.ArticleID] = true
+		} else if target, ok := before.resolve(plan.paths[l.ArticleID], l.Target, l.Wiki); ok {
+			if _, ok := after[target]; ok {
+				sources[l.ArticleID] = true
+			}
+		}
+	}
+
+	// 3. 改寫仍指向原本文章以外的連結
+	for source := range sources {
+		oldPath, newPath := plan.paths[source], plan.newPaths[source]
+		content, err := os.ReadFile(filepath.Join(cfg.SearchPath, oldPath))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		_, sourceMoved := after[source]
+		rewritten, err := rewriteLinks(content, func(l search.Link) (string, bool) {
+			target, ok := before.resolve(oldPath, l.Target, l.Wiki)
+			if !ok {
+				return "", false
+			}
+			if _, targetMoved := after[target]; !sourceMoved && !targetMoved {
+				return "", false
+			}
+			if still, ok := resolver.resolve(newPath, l.Target, l.Wiki); ok && still == target {
+				return "", false
+			}
+			return linkText(l, newPath, plan.newPaths[target], target, resolver), true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", oldPath, err)
+		}
+		if rewritten != nil {
+			plan.rewrites[source] = rewritten
+		}
+	}
+
+	ids := make([]uint, 0, len(plan.rewrites))
+	for id := range plan.rewrites {
+		ids = append(ids, id)
+	}
+	if plan.tags, err = s.repo.TagsOfArticles(ids); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// applyMove renames the file or folder and writes the rewritten links
+// within tx and files; the article rows are left to the caller.
+func (s *ArticleService) applyMove(tx repository.Tx, files *fileTx, plan *movePlan, cfg *config.Config) error {
+	for _, a := range plan.moved {
+		s.watcher.Suppress(plan.paths[a.ID])
+		s.watcher.Suppress(a.Path)
+	}
+	if err := files.Rename(filepath.Join(cfg.SearchPath, plan.from), filepath.Join(cfg.SearchPath, plan.to)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for id, data := range plan.rewrites {
+		p := plan.newPaths[id]
+		s.watcher.Suppress(p)
+		if err := files.Write(filepath.Join(cfg.SearchPath, p), data); err != nil {
+			return err
+		}
+		if err := indexArticle(tx, id, plan.titles[id], plan.tags[id], data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishMove cleans up once a move committed: the folders left empty and
+// the suggestions.
+func (s *ArticleService) finishMove(plan *movePlan, cfg *config.Config) {
+	dir := filepath.Dir(filepath.Join(cfg.SearchPath, plan.from))
+	if err := utils.RemoveEmptyParents(cfg.SearchPath, dir); err != nil {
+		log.Printf("failed to remove empty folders of %s: %v", plan.from, err)
+	}
+	s.suggest.Invalidate()
+}
+
+// updateArticles stores the new paths of the moved articles.
+func (p *movePlan) updateArticles(tx repository.Tx) error {
+	for i := range p.moved {
+		if err := tx.UpdateArticle(&p.moved[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updated returns the paths of the articles whose links changed, moved
+// articles excluded.
+func (p *movePlan) updated() []string {
+	moved := map[uint]bool{}
+	for _, a := range p.moved {
+		moved[a.ID] = true
+	}
+	paths := []string{}
+	for id := range p.rewrites {
+		if !moved[id] {
+			paths = append(paths, p.paths[id])
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Helper functions
+
+// validArticlePath tells whether p is a clean relative path to a markdown
+// file outside hidden folders.
+func validArticlePath(p string) bool {
+	return utils.ValidateMarkdownPath(p) && validFolderPath(p)
+}
+
+// validFolderPath tells whether p is a clean path below the root, without
+// hidden parts.
+func validFolderPath(p string) bool {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || strings.Contains(p, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFreePath returns ErrPathExists when an article of articles other
+// than self, or a file on disk, already takes the path p. self is the
+// article moving to p, nil for a new one.
+func checkFreePath(articles []Article, p string, self *Article, cfg *config.Config) error {
+	for _, a := range articles {
+		if a.Path == p && (self == nil || a.ID != self.ID) {
+			return ErrPathExists
+		}
+	}
+	if info, err := os.Stat(filepath.Join(cfg.SearchPath, p)); err == nil {
+		if self == nil {
+			return ErrPathExists
+		}
+		// only the same file under another case, on a case-insensitive disk
+		current, err := os.Stat(filepath.Join(cfg.SearchPath, self.Path))
+		if err != nil || !os.SameFile(info, current) {
+			return ErrPathExists
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rewriteLinks replaces the targets of the links of the body of content for
+// which replace returns true. It returns nil when nothing changed; the
+// frontmatter is kept byte for byte.
+func rewriteLinks(content []byte, replace func(search.Link) (string, bool)) ([]byte, error) {
+	doc, err := frontmatter.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	// the body is the end of the file
+	offset := len(content) - len(doc.Body)
+	var out []byte
+	last := 0
+	for _, l := range search.ParseLinks(doc.Body) {
+		text, ok := replace(l)
+		if !ok || text == l.Target {
+			continue
+		}
+		out = append(out, content[last:offset+l.Start]...)
+		out = append(out, text...)
+		last = offset + l.End
+	}
+	if out == nil {
+		return nil, nil
+	}
+	return append(out, content[last:]...), nil
+}
+
+// linkText returns the new target of link, written in the article at
+// source, to the article target now at targetPath, in the style of the
+// original link.
+func linkText(link search.Link, source, targetPath string, target uint, r *linkResolver) string {
+	if link.Wiki {
+		name := strings.TrimSuffix(targetPath, ".md")
+		if !strings.Contains(link.Target, "/") {
+			// keep a bare name when it is not ambiguous
+			if id, ok := r.byName[strings.ToLower(path.Base(name))]; ok && id == target {
+				name = path.Base(name)
+			}
+		}
+		if strings.HasSuffix(strings.ToLower(link.Target), ".md") {
+			name += ".md"
+		}
+		return name
+	}
+	text := relativePath(path.Dir(source), targetPath)
+	if strings.HasPrefix(link.Target, "/") {
+		text = "/" + targetPath
+	}
+	if strings.Contains(link.Target, "%") {
+		return escapePath(text)
+	}
+	return strings.ReplaceAll(text, " ", "%20")
+}
+
+// relativePath returns the path of target relative to the folder dir, both
+// relative to the root.
+func relativePath(dir, target string) string {
+	var from []string
+	if dir != "." {
+		from = strings.Split(dir, "/")
+	}
+	to := strings.Split(target, "/")
+	n := 0
+	for n < len(from) && n < len(to)-1 && from[n] == to[n] {
+		n++
+	}
+	parts := make([]string, 0, len(from)-n+len(to)-n)
+	for range from[n:] {
+		parts = append(parts, "..")
+	}
+	return strings.Join(append(parts, to[n:]...), "/")
+}
+
+// escapePath escapes every segment of a slash separated path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
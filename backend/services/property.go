@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"pkms/backend/frontmatter"
+)
+
+var ErrReservedProperty = errors.New("property is an article field")
+
+// articleFields are the frontmatter keys kept in the articles and tags
+// tables; every other key is a custom property.
+var articleFields = map[string]struct{}{
+	"title":       {},
+	"tags":        {},
+	"type":        {},
+	"create_date": {},
+	"edit_date":   {},
+}
+
+// customProperties returns the frontmatter keys that are not article fields.
+func customProperties(doc *frontmatter.Document) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	for _, key := range doc.Keys() {
+		if _, ok := articleFields[key]; ok {
+			continue
+		}
+		var value interface{}
+		if _, err := doc.Decode(key, &value); err != nil {
+			return nil, err
+		}
+		value = plainDates(value)
+		// must be storable as JSON (maps with non-string keys are not)
+		if _, err := json.Marshal(value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", frontmatter.ErrInvalid, key, err)
+		}
+		properties[key] = value
+	}
+	return properties, nil
+}
+
+// plainDates turns the timestamps YAML decodes into the strings they were
+// written as (as near as the value allows), so dates are stored, filtered
+// and compared as text like the other properties.
+func plainDates(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		if v.Equal(v.Truncate(24*time.Hour)) && v.Location() == time.UTC {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	case []interface{}:
+		for i := range v {
+			v[i] = plainDates(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = plainDates(v[k])
+		}
+	}
+	return value
+}
+
+// setProperties merges properties into the frontmatter; a nil value
+// removes the key.
+func setProperties(doc *frontmatter.Document, properties map[string]interface{}) error {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		if _, ok := articleFields[name]; ok || name == "" {
+			return fmt.Errorf("%w: %q", ErrReservedProperty, name)
+		}
+		names = append(names, name)
+	}
+	// new keys are appended in a stable order
+	sort.Strings(names)
+	for _, name := range names {
+		if properties[name] == nil {
+			doc.Delete(name)
+			continue
+		}
+		if err := doc.Set(name, properties[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propertyChanges lists the properties that differ, one change per name.
+func propertyChanges(current, next map[string]interface{}) []FieldChange {
+	names := map[string]struct{}{}
+	for name := range current {
+		names[name] = struct{}{}
+	}
+	for name := range next {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []FieldChange
+	for _, name := range sorted {
+		from, to := propertyString(current, name), propertyString(next, name)
+		if from != to {
+			changes = append(changes, FieldChange{"properties." + name, from, to})
+		}
+	}
+	return changes
+}
+
+// propertyString is the JSON form of a property, "" when it is missing.
+// JSON makes values loaded from the database and from YAML comparable.
+func propertyString(properties map[string]interface{}, name string) string {
+	value, ok := properties[name]
+	if !ok {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,461 @@
+package services
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pkms/backend/frontmatter"
+	"pkms/backend/repository"
+	"pkms/backend/search"
+)
+
+// prefixWeight discounts a word that only matches the start of a longer
+// term, so "note" ranks "note" above "notebook". fuzzyWeight does the same
+// for every typo between a word and a term.
+const (
+	prefixWeight = 0.7
+	fuzzyWeight  = 0.5
+)
+
+// QueryOptions tunes how text clauses match.
+type QueryOptions struct {
+	// Fuzzy lets a word also match the indexed terms a few typos away
+	// (see search.MaxEdits) that start with the same letter.
+	Fuzzy bool
+}
+
+// Query returns the articles among candidates matching query, with their
+// BM25F score over the text clauses that are not negated.
+func (s *IndexService) Query(query search.Node, candidates []Article, opts QueryOptions) (map[uint]*IndexMatch, error) {
+	// 1. 取得 query 中所有 term 的 postings
+	var terms []string
+	seen := map[string]struct{}{}
+	needTags := false
+	for _, c := range search.AllClauses(query) {
+		if c.Field == search.FieldTag {
+			needTags = true
+		}
+		for _, t := range c.Terms {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				terms = append(terms, t)
+			}
+		}
+	}
+	postings, err := s.repo.SearchPostings(terms, true)
+	if err != nil {
+		return nil, err
+	}
+	var fuzzy map[string]map[string]int
+	if opts.Fuzzy {
+		if fuzzy, err = s.fuzzyTerms(query); err != nil {
+			return nil, err
+		}
+		var typos []string
+		for _, expansions := range fuzzy {
+			for term := range expansions {
+				typos = append(typos, term)
+			}
+		}
+		more, err := s.repo.SearchPostings(typos, false)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, more...)
+	}
+	idx := newPostingIndex(postings, fuzzy)
+	var tags map[uint][]string
+	if needTags {
+		if tags, err = articleTagNames(s.repo); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 逐篇文章比對 query
+	matches := map[uint]*IndexMatch{}
+	var ids []uint
+	for i := range candidates {
+		a := &candidates[i]
+		if idx.matchNode(query, a, tags[a.ID]) {
+			matches[a.ID] = &IndexMatch{ArticleID: a.ID}
+			ids = append(ids, a.ID)
+		}
+	}
+	clauses := search.TextClauses(query)
+	if len(clauses) == 0 || len(matches) == 0 {
+		return matches, nil
+	}
+
+	// 3. BM25F: 加總文章符合的 text clauses
+	stats, err := s.repo.IndexStats()
+	if err != nil {
+		return nil, err
+	}
+	lengths, err := s.repo.IndexLengths(ids)
+	if err != nil {
+		return nil, err
+	}
+	for id, match := range matches {
+		match.InTitle = true
+		for _, c := range clauses {
+			fields := clauseFields(c)
+			if !idx.matchText(id, c, fields, true) {
+				// the other side of an OR
+				match.InTitle = false
+				continue
+			}
+			match.Score += idx.score(id, c, fields, stats, lengths[id])
+			idx.collectHits(match, c)
+			if !idx.matchText(id, c, fields, false) {
+				match.Fuzzy = true
+			}
+			if !idx.matchText(id, c, []string{search.FieldTitle}, true) {
+				match.InTitle = false
+			}
+		}
+	}
+	return matches, nil
+}
+
+// fuzzyTerms maps every word of query that tolerates typos to the indexed
+// terms within reach and their edit distance. Terms the word already
+// starts are left out.
+func (s *IndexService) fuzzyTerms(query search.Node) (map[string]map[string]int, error) {
+	var words, prefixes []string
+	seen := map[string]struct{}{}
+	for _, c := range search.AllClauses(query) {
+		if !c.IsText() || c.Phrase || len(c.Terms) == 0 || search.MaxEdits(c.Terms[0]) == 0 {
+			continue
+		}
+		word := c.Terms[0]
+		words = append(words, word)
+		first := string([]rune(word)[:1])
+		if _, ok := seen[first]; !ok {
+			seen[first] = struct{}{}
+			prefixes = append(prefixes, first)
+		}
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+	terms, err := s.repo.SearchTerms(prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	fuzzy := map[string]map[string]int{}
+	for _, word := range words {
+		for _, term := range terms {
+			if strings.HasPrefix(term, word) {
+				continue
+			}
+			if d, ok := search.EditDistance(word, term, search.MaxEdits(word)); ok {
+				if fuzzy[word] == nil {
+					fuzzy[word] = map[string]int{}
+				}
+				fuzzy[word][term] = d
+			}
+		}
+	}
+	return fuzzy, nil
+}
+
+// maxSnippets is the number of snippets returned per article.
+const maxSnippets = 3
+
+// Snippets returns the pieces of the article body around the words and
+// phrases it matched.
+func (s *IndexService) Snippets(article *Article, match *IndexMatch) ([]search.Snippet, error) {
+	if len(match.words) == 0 && len(match.phrases) == 0 {
+		return nil, nil
+	}
+	body, err := s.readBody(article)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 單字: token 是比對到的 term
+	tokens := search.Tokenize(body)
+	var hits []search.Span
+	for _, t := range tokens {
+		word, ok := match.words[t.Term]
+		if !ok {
+			continue
+		}
+		end := t.End
+		if r, size := utf8.DecodeRuneInString(body[t.Start:]); word != t.Term && search.IsCJK(r) {
+			// only the characters typed of a CJK bigram
+			end = t.Start + size
+		}
+		hits = append(hits, search.Span{Start: t.Start, End: end})
+	}
+
+	// 2. 片語: 連續位置的 tokens (略過與前一個同位置的單字)
+	var seq []search.Token
+	for i, t := range tokens {
+		if i == 0 || t.Pos != tokens[i-1].Pos {
+			seq = append(seq, t)
+		}
+	}
+	for _, phrase := range match.phrases {
+		for i := 0; i+len(phrase) <= len(seq); i++ {
+			found := true
+			for j, term := range phrase {
+				if seq[i+j].Term != term {
+					found = false
+					break
+				}
+			}
+			if found {
+				hits = append(hits, search.Span{Start: seq[i].Start, End: seq[i+len(phrase)-1].End})
+			}
+		}
+	}
+	return search.Snippets(body, search.MergeSpans(hits), maxSnippets), nil
+}
+
+// postingIndex holds the postings of the query terms by article.
+type postingIndex struct {
+	// article -> term -> field -> posting
+	postings map[uint]map[string]map[string]*repository.Posting
+	// docs counts the articles containing a term.
+	docs map[string]int
+	// fuzzy maps a word to the terms it matches despite typos.
+	fuzzy map[string]map[string]int
+}
+
+func newPostingIndex(postings []repository.Posting, fuzzy map[string]map[string]int) *postingIndex {
+	idx := &postingIndex{
+		postings: map[uint]map[string]map[string]*repository.Posting{},
+		docs:     map[string]int{},
+		fuzzy:    fuzzy,
+	}
+	for i := range postings {
+		p := &postings[i]
+		terms := idx.postings[p.ArticleID]
+		if terms == nil {
+			terms = map[string]map[string]*repository.Posting{}
+			idx.postings[p.ArticleID] = terms
+		}
+		if terms[p.Term] == nil {
+			terms[p.Term] = map[string]*repository.Posting{}
+			idx.docs[p.Term]++
+		}
+		terms[p.Term][p.Field] = p
+	}
+	return idx
+}
+
+func (x *postingIndex) matchNode(node search.Node, a *Article, tags []string) bool {
+	switch n := node.(type) {
+	case *search.And:
+		for _, child := range n.Nodes {
+			if !x.matchNode(child, a, tags) {
+				return false
+			}
+		}
+		return true
+	case *search.Or:
+		for _, child := range n.Nodes {
+			if x.matchNode(child, a, tags) {
+				return true
+			}
+		}
+		return false
+	case *search.Not:
+		return !x.matchNode(n.Node, a, tags)
+	case *search.Clause:
+		return x.matchClause(n, a, tags)
+	}
+	return false
+}
+
+func (x *postingIndex) matchClause(c *search.Clause, a *Article, tags []string) bool {
+	switch c.Field {
+	case search.FieldTag:
+		for _, tag := range tags {
+			if strings.EqualFold(tag, c.Value) {
+				return true
+			}
+		}
+		return false
+	case search.FieldPath:
+		return strings.Contains(strings.ToLower(a.Path), strings.ToLower(c.Value))
+	case search.FieldType:
+		return strings.EqualFold(a.Type, c.Value)
+	case search.FieldCreated:
+		return matchDate(a.CreateDate, c)
+	case search.FieldEdited:
+		return matchDate(a.EditDate, c)
+	}
+	return x.matchText(a.ID, c, clauseFields(c), true)
+}
+
+// matchText tells whether a text clause occurs in one of fields. A word
+// matches every term it starts (and, with fuzzy set, the terms a few typos
+// away), a phrase its terms at consecutive positions. A clause without
+// terms (only punctuation) matches anything.
+func (x *postingIndex) matchText(id uint, c *search.Clause, fields []string, fuzzy bool) bool {
+	if len(c.Terms) == 0 {
+		return true
+	}
+	terms := x.postings[id]
+	if !c.Phrase {
+		for term, byField := range terms {
+			weight := x.weight(c.Terms[0], term)
+			if weight == 0 || (!fuzzy && weight < prefixWeight) {
+				continue
+			}
+			for _, f := range fields {
+				if byField[f] != nil {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, f := range fields {
+		first := terms[c.Terms[0]][f]
+		if first == nil {
+			continue
+		}
+		for _, start := range first.Positions {
+			if phraseAt(terms, c.Terms, f, start) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// score is the BM25F score of a matching text clause: the best term a word
+// expands to, or the sum over the terms of a phrase.
+func (x *postingIndex) score(id uint, c *search.Clause, fields []string, stats *search.Stats, lengths map[string]int) float64 {
+	terms := x.postings[id]
+	termScore := func(term string) float64 {
+		tf := map[string]int{}
+		for _, f := range fields {
+			if p := terms[term][f]; p != nil {
+				tf[f] = p.TF
+			}
+		}
+		return search.TermScore(search.IDF(stats.Documents, x.docs[term]), tf, lengths, stats)
+	}
+
+	total := 0.0
+	if c.Phrase {
+		seen := map[string]struct{}{}
+		for _, t := range c.Terms {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				total += termScore(t)
+			}
+		}
+		return total
+	}
+	for term := range terms {
+		weight := x.weight(c.Terms[0], term)
+		if weight == 0 {
+			continue
+		}
+		if score := weight * termScore(term); score > total {
+			total = score
+		}
+	}
+	return total
+}
+
+// collectHits remembers what a clause matched in the body of an article.
+func (x *postingIndex) collectHits(match *IndexMatch, c *search.Clause) {
+	if len(c.Terms) == 0 || (c.Field != "" && c.Field != search.FieldBody && c.Field != search.FieldHeading) {
+		return
+	}
+	if c.Phrase {
+		match.phrases = append(match.phrases, c.Terms)
+		return
+	}
+	if match.words == nil {
+		match.words = map[string]string{}
+	}
+	for term := range x.postings[match.ArticleID] {
+		if x.weight(c.Terms[0], term) > 0 {
+			match.words[term] = c.Terms[0]
+		}
+	}
+}
+
+// weight tells how well a term matches a word: 1 when equal, less when the
+// word only starts the term or is a typo of it, 0 when it does not match.
+func (x *postingIndex) weight(word, term string) float64 {
+	switch {
+	case term == word:
+		return 1
+	case strings.HasPrefix(term, word):
+		return prefixWeight
+	}
+	if d, ok := x.fuzzy[word][term]; ok {
+		return math.Pow(fuzzyWeight, float64(d))
+	}
+	return 0
+}
+
+// readBody returns the body of the article file, without frontmatter.
+func (s *IndexService) readBody(article *Article) (string, error) {
+	content, err := os.ReadFile(s.articlePath(article))
+	if err != nil {
+		return "", err
+	}
+	doc, err := frontmatter.Parse(content)
+	if err != nil {
+		return "", err
+	}
+	return doc.Body, nil
+}
+
+func (s *IndexService) articlePath(article *Article) string {
+	return filepath.Join(s.root, filepath.FromSlash(article.Path))
+}
+
+// Helper functions
+
+func clauseFields(c *search.Clause) []string {
+	if c.Field == "" {
+		return search.Fields
+	}
+	return []string{c.Field}
+}
+
+// phraseAt tells whether phrase occurs in field starting at position start.
+func phraseAt(terms map[string]map[string]*repository.Posting, phrase []string, field string, start int) bool {
+	for i, t := range phrase[1:] {
+		p := terms[t][field]
+		if p == nil {
+			return false
+		}
+		want := start + i + 1
+		if j := sort.SearchInts(p.Positions, want); j == len(p.Positions) || p.Positions[j] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDate compares t with the day, month or year [From, To) of c.
+func matchDate(t time.Time, c *search.Clause) bool {
+	switch c.Op {
+	case "<":
+		return t.Before(c.From)
+	case "<=":
+		return t.Before(c.To)
+	case ">":
+		return !t.Before(c.To)
+	case ">=":
+		return !t.Before(c.From)
+	}
+	return !t.Before(c.From) && t.Before(c.To)
+}
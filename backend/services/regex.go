@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+
+	"pkms/backend/search"
+)
+
+// maxRegexMatches caps the matches counted and highlighted per article.
+const maxRegexMatches = 1000
+
+// regexHits searches the files of candidates for re. Files are read in
+// the order of candidates until the size budget is spent; truncated tells
+// that some were left out. Files missing on disk, e.g. deleted before the
+// watcher synced, are skipped.
+func (s *SearchService) regexHits(ctx context.Context, re *regexp.Regexp, candidates []Article) (hits []SearchHit, truncated bool, err error) {
+	// 1. 依大小預算決定要讀的檔案
+	var scan []Article
+	var size int64
+	for i := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		info, err := os.Stat(s.index.articlePath(&candidates[i]))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", candidates[i].Path, err)
+		}
+		if size += info.Size(); size > s.regexBytes {
+			truncated = true
+			break
+		}
+		scan = append(scan, candidates[i])
+	}
+
+	// 2. 平行讀檔比對
+	found := make([]*SearchHit, len(scan))
+	err = forEach(ctx, len(scan), func(i int) error {
+		a := &scan[i]
+		body, err := s.index.readBody(a)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", a.Path, err)
+		}
+		found[i] = regexHit(re, a, body)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, h := range found {
+		if h != nil {
+			hits = append(hits, *h)
+		}
+	}
+	return hits, truncated, nil
+}
+
+// Helper functions
+
+// regexHit matches re against the title and body of an article, nil when
+// neither matches. Title matches count like a title field hit, body matches
+// by their logarithm.
+func regexHit(re *regexp.Regexp, article *Article, body string) *SearchHit {
+	matches := re.FindAllStringIndex(body, maxRegexMatches)
+	inTitle := re.MatchString(article.Title)
+	if len(matches) == 0 && !inTitle {
+		return nil
+	}
+
+	hit := &SearchHit{Article: article, Sort: 2}
+	hit.Score = math.Log1p(float64(len(matches))) + PopularityBonus(article)
+	if inTitle {
+		hit.Sort = 1
+		hit.Score += search.FieldBoosts[search.FieldTitle]
+	}
+	var spans []search.Span
+	for _, m := range matches {
+		// empty matches (^, \b) have nothing to highlight
+		if m[1] > m[0] {
+			spans = append(spans, search.Span{Start: m[0], End: m[1]})
+		}
+	}
+	if len(spans) > 0 {
+		hit.Snippets = search.Snippets(body, search.MergeSpans(spans), maxSnippets)
+	}
+	return hit
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"pkms/backend/repository"
+	"pkms/backend/search"
+)
+
+// Weights of the signals of RelatedArticles, each scored from 0 to 1:
+// shared tags, shared folder, links and content similarity.
+const (
+	relatedTagWeight     = 1
+	relatedFolderWeight  = 0.5
+	relatedLinkWeight    = 1.5
+	relatedContentWeight = 2
+)
+
+// relatedTerms is the number of the most characteristic terms of an article
+// compared with the other articles.
+const relatedTerms = 25
+
+// RelatedArticle is an article close to another one, with the reasons.
+type RelatedArticle struct {
+	ID    uint    `json:"id"`
+	Title string  `json:"title"`
+	Path  string  `json:"path"`
+	Score float64 `json:"score"`
+	// SharedTags are the tags both articles carry.
+	SharedTags []string `json:"shared_tags,omitempty"`
+	// Folder is the deepest folder holding both articles.
+	Folder string `json:"folder,omitempty"`
+	// LinkDistance is 1 when one article links to the other, 2 when they
+	// link to or from the same note.
+	LinkDistance int `json:"link_distance,omitempty"`
+	// Similarity compares the words of both articles, from 0 to 1.
+	Similarity float64 `json:"similarity"`
+}
+
+// RelatedService recommends articles to read after another one.
+type RelatedService struct {
+	repo repository.Repository
+}
+
+func NewRelatedService(repo repository.Repository) *RelatedService {
+	return &RelatedService{repo: repo}
+}
+
+// RelatedArticles returns at most limit articles closest to article id,
+// ranked by shared tags (rare tags count more), shared folder, links
+// between them and content similarity.
+func (s *RelatedService) RelatedArticles(id uint, limit int) ([]RelatedArticle, error) {
+	article, err := s.repo.GetArticle(id)
+	if err == repository.ErrNotFound {
+		return nil, ErrArticleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	tags, err := articleTagNames(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	distances, err := s.linkDistances(id, articles)
+	if err != nil {
+		return nil, err
+	}
+	similarity, err := s.similarity(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. tag 的稀有程度: IDF
+	tagDocs := map[string]int{}
+	for _, names := range tags {
+		for _, name := range names {
+			tagDocs[name]++
+		}
+	}
+	own := map[string]float64{}
+	ownTotal := 0.0
+	for _, name := range tags[id] {
+		own[name] = search.IDF(len(articles), tagDocs[name])
+		ownTotal += own[name]
+	}
+
+	// 2. 每篇文章加總四種訊號
+	var related []RelatedArticle
+	for i := range articles {
+		a := &articles[i]
+		if a.ID == id {
+			continue
+		}
+		r := RelatedArticle{ID: a.ID, Title: a.Title, Path: a.Path, LinkDistance: distances[a.ID]}
+		shared := 0.0
+		for _, name := range tags[a.ID] {
+			if w, ok := own[name]; ok {
+				shared += w
+				r.SharedTags = append(r.SharedTags, name)
+			}
+		}
+		if shared > 0 {
+			r.Score += relatedTagWeight * shared / ownTotal
+		}
+		folder, depth := sharedFolder(article.Path, a.Path)
+		if depth > 0 {
+			r.Folder = folder
+			if folder == path.Dir(article.Path) && folder == path.Dir(a.Path) {
+				r.Score += relatedFolderWeight
+			} else {
+				r.Score += relatedFolderWeight / 2
+			}
+		}
+		if r.LinkDistance > 0 {
+			r.Score += relatedLinkWeight / float64(r.LinkDistance)
+		}
+		r.Similarity = math.Round(similarity[a.ID]*1000) / 1000
+		r.Score += relatedContentWeight * similarity[a.ID]
+		if r.Score > 0 {
+			r.Score = math.Round(r.Score*1000) / 1000
+			related = append(related, r)
+		}
+	}
+	sort.SliceStable(related, func(i, j int) bool { return related[i].Score > related[j].Score })
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
+
+// linkDistances returns how many links away the articles are from article
+// id, 1 or 2, in either direction.
+func (s *RelatedService) linkDistances(id uint, articles []Article) (map[uint]int, error) {
+	links, err := s.repo.ArticleLinks()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[uint]string, len(articles))
+	for _, a := range articles {
+		paths[a.ID] = a.Path
+	}
+	resolver := newLinkResolver(articles)
+	neighbours := map[uint]map[uint]struct{}{}
+	connect := func(a, b uint) {
+		if neighbours[a] == nil {
+			neighbours[a] = map[uint]struct{}{}
+		}
+		neighbours[a][b] = struct{}{}
+	}
+	for _, l := range links {
+		target, ok := resolver.resolve(paths[l.ArticleID], l.Target, l.Wiki)
+		if !ok || target == l.ArticleID {
+			continue
+		}
+		connect(l.ArticleID, target)
+		connect(target, l.ArticleID)
+	}
+
+	distances := map[uint]int{}
+	for n := range neighbours[id] {
+		distances[n] = 1
+	}
+	for n := range neighbours[id] {
+		for m := range neighbours[n] {
+			if _, ok := distances[m]; !ok && m != id {
+				distances[m] = 2
+			}
+		}
+	}
+	return distances, nil
+}
+
+// similarity compares article id with the articles sharing its most
+// characteristic terms: the terms with the highest TF-IDF are searched for
+// like a query and the BM25F scores divided by the score of the article
+// itself.
+func (s *RelatedService) similarity(id uint) (map[uint]float64, error) {
+	// 1. 文章自己的 terms，各欄位依 boost 加權
+	postings, err := s.repo.ArticlePostings(id)
+	if err != nil {
+		return nil, err
+	}
+	tf := map[string]float64{}
+	for _, p := range postings {
+		tf[p.Term] += search.FieldBoosts[p.Field] * float64(p.TF)
+	}
+	terms := make([]string, 0, len(tf))
+	for term := range tf {
+		terms = append(terms, term)
+	}
+	docs, err := s.repo.TermDocs(terms)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.repo.IndexStats()
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 取 TF-IDF 最高的 terms
+	weights := map[string]float64{}
+	for _, term := range terms {
+		if docs[term] > 1 {
+			weights[term] = (1 + math.Log(tf[term])) * search.IDF(stats.Documents, docs[term])
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if weights[terms[i]] != weights[terms[j]] {
+			return weights[terms[i]] > weights[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	for len(terms) > 0 && weights[terms[len(terms)-1]] == 0 {
+		terms = terms[:len(terms)-1]
+	}
+	if len(terms) > relatedTerms {
+		terms = terms[:relatedTerms]
+	}
+	if len(terms) == 0 {
+		return map[uint]float64{}, nil
+	}
+
+	// 3. 用這些 terms 查詢，以 BM25F 計分
+	matched, err := s.repo.SearchPostings(terms, false)
+	if err != nil {
+		return nil, err
+	}
+	byArticle := map[uint]map[string]map[string]int{}
+	var ids []uint
+	for _, p := range matched {
+		if byArticle[p.ArticleID] == nil {
+			byArticle[p.ArticleID] = map[string]map[string]int{}
+			ids = append(ids, p.ArticleID)
+		}
+		if byArticle[p.ArticleID][p.Term] == nil {
+			byArticle[p.ArticleID][p.Term] = map[string]int{}
+		}
+		byArticle[p.ArticleID][p.Term][p.Field] = p.TF
+	}
+	lengths, err := s.repo.IndexLengths(ids)
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[uint]float64, len(ids))
+	for _, other := range ids {
+		for _, term := range terms {
+			if fields := byArticle[other][term]; fields != nil {
+				scores[other] += weights[term] * search.TermScore(search.IDF(stats.Documents, docs[term]), fields, lengths[other], stats)
+			}
+		}
+	}
+	self := scores[id]
+	if self == 0 {
+		return map[uint]float64{}, nil
+	}
+	for other, score := range scores {
+		if scores[other] = score / self; scores[other] > 1 {
+			scores[other] = 1
+		}
+	}
+	return scores, nil
+}
+
+// Helper functions
+
+// sharedFolder returns the deepest folder holding both paths and its
+// depth, 0 when they only share the root.
+func sharedFolder(a, b string) (string, int) {
+	pa := strings.Split(path.Dir(a), "/")
+	pb := strings.Split(path.Dir(b), "/")
+	n := 0
+	for n < len(pa) && n < len(pb) && pa[n] == pb[n] && pa[n] != "." {
+		n++
+	}
+	return strings.Join(pa[:n], "/"), n
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pkms/backend/models"
+	"pkms/backend/repository"
+	"pkms/backend/utils"
+)
+
+var (
+	ErrInvalidBackup   = errors.New("invalid backup archive")
+	ErrTargetNotEmpty  = errors.New("restore target is not empty")
+	ErrRestoreMismatch = errors.New("restored data does not match the backup")
+)
+
+// RestoreOptions controls BackupService.Restore.
+type RestoreOptions struct {
+	// Force wipes the database rows and article files of a non-empty target.
+	Force bool
+	// DryRun only validates the archive and computes the diff.
+	DryRun bool
+}
+
+// RestoreReport summarizes what a restore changed compared to the target's
+// previous state.
+type RestoreReport struct {
+	Manifest *BackupManifest `json:"manifest"`
+	Articles DiffCounts      `json:"articles"`
+	Tags     DiffCounts      `json:"tags"`
+	Files    DiffCounts      `json:"files"`
+}
+
+// DiffCounts compares two sets of items by key (article path, tag name,
+// file path).
+type DiffCounts struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// backupArchive is a fully read and verified backup.
+type backupArchive struct {
+	manifest   *BackupManifest
+	articles   []models.Article
+	tags       []models.Tag
+	links      []models.ArticleTag
+	properties []models.ArticleProperty
+	// files maps the path relative to the articles root to its content.
+	files map[string][]byte
+}
+
+// Restore reads an archive written by Create, verifies it against its
+// manifest and loads its rows and files into the target. The target must be
+// empty unless opts.Force is set.
+func (s *BackupService) Restore(r io.Reader, opts RestoreOptions) (*RestoreReport, error) {
+	archive, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 目前的狀態
+	curArticles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	curTags, err := s.repo.FindTags("")
+	if err != nil {
+		return nil, err
+	}
+	curFiles, err := s.fileChecksums()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Force && (len(curArticles) > 0 || len(curTags) > 0 || len(curFiles) > 0) {
+		return nil, fmt.Errorf("%w: %d articles, %d tags, %d files", ErrTargetNotEmpty, len(curArticles), len(curTags), len(curFiles))
+	}
+
+	// 2. diff
+	report := &RestoreReport{Manifest: archive.manifest}
+	report.Articles = diffArticles(curArticles, archive.articles)
+	report.Tags = diffTags(curTags, archive.tags)
+	newFiles := make(map[string]string, len(archive.files))
+	for rel := range archive.files {
+		newFiles[rel] = archive.manifest.Checksums[path.Join(backupFilesDir, rel)]
+	}
+	report.Files = diffKeys(curFiles, newFiles)
+	if opts.DryRun {
+		return report, nil
+	}
+
+	// 3. 寫入 DB 與檔案
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	files := &fileTx{}
+	defer files.Rollback()
+
+	if err := tx.DeleteAll(); err != nil {
+		return nil, err
+	}
+	for i := range archive.tags {
+		if err := tx.RestoreTag(&archive.tags[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range archive.articles {
+		if err := tx.RestoreArticle(&archive.articles[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range archive.links {
+		if err := tx.RestoreArticleTag(&archive.links[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range archive.properties {
+		if err := tx.RestoreArticleProperty(&archive.properties[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for rel := range curFiles {
+		if err := files.Remove(filepath.Join(s.root, filepath.FromSlash(rel))); err != nil {
+			return nil, err
+		}
+	}
+	for rel, data := range archive.files {
+		if err := files.Write(filepath.Join(s.root, filepath.FromSlash(rel)), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	files.Commit()
+	// 舊檔案留下的空資料夾，commit 後才刪，rollback 時不必還原
+	if err := utils.RemoveEmptyDirs(s.root); err != nil {
+		log.Printf("failed to remove empty folders: %v", err)
+	}
+
+	// 4. 驗證
+	if err := s.verifyRestore(archive); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// verifyRestore re-reads the target and compares it with the manifest.
+func (s *BackupService) verifyRestore(archive *backupArchive) error {
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return err
+	}
+	tags, err := s.repo.FindTags("")
+	if err != nil {
+		return err
+	}
+	links, err := s.repo.ArticleTagLinks()
+	if err != nil {
+		return err
+	}
+	properties, err := s.repo.ArticlePropertyRows()
+	if err != nil {
+		return err
+	}
+	files, err := s.fileChecksums()
+	if err != nil {
+		return err
+	}
+
+	counts := archive.manifest.Counts
+	if len(articles) != counts.Articles || len(tags) != counts.Tags || len(links) != counts.ArticleTags || len(properties) != counts.Properties {
+		return fmt.Errorf("%w: got %d articles, %d tags, %d article_tags, %d article_properties",
+			ErrRestoreMismatch, len(articles), len(tags), len(links), len(properties))
+	}
+	for rel := range archive.files {
+		if files[rel] != archive.manifest.Checksums[path.Join(backupFilesDir, rel)] {
+			return fmt.Errorf("%w: file %s", ErrRestoreMismatch, rel)
+		}
+	}
+	return nil
+}
+
+// fileChecksums returns the sha256 of every file under the articles root,
+// keyed by slash separated relative path.
+func (s *BackupService) fileChecksums() (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == s.root {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = checksum(data)
+		return nil
+	})
+	return files, err
+}
+
+// readBackup loads every entry of the archive and checks it against the
+// manifest: every entry must be listed with a matching checksum, every
+// listed entry must be present and the row counts must match.
+func readBackup(r io.Reader) (*backupArchive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer gz.Close()
+
+	entries := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+			return nil, fmt.Errorf("%w: unsafe entry %s", ErrInvalidBackup, header.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		entries[name] = data
+	}
+
+	data, ok := entries[backupManifestName]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidBackup, backupManifestName)
+	}
+	delete(entries, backupManifestName)
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, backupManifestName, err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > BackupFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBackup, manifest.FormatVersion)
+	}
+
+	// checksums
+	for name, data := range entries {
+		want, ok := manifest.Checksums[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in the manifest", ErrInvalidBackup, name)
+		}
+		if checksum(data) != want {
+			return nil, fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidBackup, name)
+		}
+	}
+	for name := range manifest.Checksums {
+		if _, ok := entries[name]; !ok {
+			return nil, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, name)
+		}
+	}
+
+	archive := &backupArchive{manifest: &manifest, files: map[string][]byte{}}
+	tables := []struct {
+		name string
+		rows interface{}
+		// since is the first format version with the table
+		since int
+	}{
+		{"articles", &archive.articles, 1},
+		{"tags", &archive.tags, 1},
+		{"article_tags", &archive.links, 1},
+		{"article_properties", &archive.properties, 2},
+	}
+	for _, table := range tables {
+		name := path.Join(backupDBDir, table.name+".json")
+		data, ok := entries[name]
+		if !ok && manifest.FormatVersion < table.since {
+			continue
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidBackup, name)
+		}
+		if err := json.Unmarshal(data, table.rows); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, name, err)
+		}
+	}
+	for name, data := range entries {
+		if rel := strings.TrimPrefix(name, backupFilesDir+"/"); rel != name {
+			archive.files[rel] = data
+		}
+	}
+
+	counts := manifest.Counts
+	if len(archive.articles) != counts.Articles || len(archive.tags) != counts.Tags ||
+		len(archive.links) != counts.ArticleTags || len(archive.properties) != counts.Properties ||
+		len(archive.files) != counts.Files {
+		return nil, fmt.Errorf("%w: row or file counts do not match the manifest", ErrInvalidBackup)
+	}
+	return archive, nil
+}
+
+func diffArticles(cur, next []models.Article) DiffCounts {
+	curByPath := make(map[string]string, len(cur))
+	for _, a := range cur {
+		curByPath[a.Path] = articleFingerprint(a)
+	}
+	nextByPath := make(map[string]string, len(next))
+	for _, a := range next {
+		nextByPath[a.Path] = articleFingerprint(a)
+	}
+	return diffKeys(curByPath, nextByPath)
+}
+
+func articleFingerprint(a models.Article) string {
+	return fmt.Sprintf("%d|%s|%s|%d|%d|%d|%t",
+		a.ID, a.Title, a.Type, a.CreateDate.Unix(), a.EditDate.Unix(), a.RefCount, a.Pin)
+}
+
+func diffTags(cur, next []models.Tag) DiffCounts {
+	curByName := make(map[string]string, len(cur))
+	for _, t := range cur {
+		curByName[t.Name] = fmt.Sprint(t.ID)
+	}
+	nextByName := make(map[string]string, len(next))
+	for _, t := range next {
+		nextByName[t.Name] = fmt.Sprint(t.ID)
+	}
+	return diffKeys(curByName, nextByName)
+}
+
+// diffKeys compares two key -> fingerprint maps.
+func diffKeys(cur, next map[string]string) DiffCounts {
+	var d DiffCounts
+	for key, value := range next {
+		old, ok := cur[key]
+		switch {
+		case !ok:
+			d.Added++
+		case old != value:
+			d.Changed++
+		default:
+			d.Unchanged++
+		}
+	}
+	for key := range cur {
+		if _, ok := next[key]; !ok {
+			d.Removed++
+		}
+	}
+	return d
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"pkms/backend/config"
+	"pkms/backend/repository"
+	"pkms/backend/search"
+)
+
+// SortRelevance orders the results of a text query by score; it is the
+// default sort of text queries.
+const SortRelevance = "relevance"
+
+// maxSnippetResults limits reading article files to the top results.
+// searchWorkers is the number of files read at the same time.
+const (
+	maxSnippetResults = 50
+	searchWorkers     = 8
+)
+
+// SearchService answers searches: it matches the query against the index,
+// orders and pages the results and fills the page with tags, snippets and
+// facets in a fixed number of queries.
+type SearchService struct {
+	repo    repository.Repository
+	index   *IndexService
+	timeout time.Duration
+	// regexBytes is the size budget of a regex search.
+	regexBytes int64
+}
+
+func NewSearchService(repo repository.Repository, index *IndexService, cfg *config.Config) *SearchService {
+	return &SearchService{repo: repo, index: index, timeout: cfg.SearchTimeout, regexBytes: cfg.RegexMaxBytes}
+}
+
+// SearchRequest describes one page of a search.
+type SearchRequest struct {
+	// Query is matched against the index; nil lists the articles of Filter.
+	Query   search.Node
+	Options QueryOptions
+	// Regex, when set instead of Query, is searched for in the files.
+	Regex *regexp.Regexp
+	// Filter narrows the articles; its Sort, Limit and After are ignored.
+	Filter repository.ArticleFilter
+
+	// Relevance orders a text query by score, otherwise Sort applies.
+	Relevance bool
+	Sort      repository.ArticleSort
+	// Limit is the page size (0: every result). A page starts after
+	// Offset results when sorted by relevance, after After otherwise.
+	Limit  int
+	Offset int
+	After  *repository.ArticleCursor
+}
+
+// SearchCursor is the position after the last result of a page. Relevance
+// pages are counted by offset, the other sorts continue after the last
+// article (keyset pagination).
+type SearchCursor struct {
+	Sort   string `json:"sort"`
+	ID     uint   `json:"id,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// SearchHit is an article of the page.
+type SearchHit struct {
+	Article *Article
+	// Sort is 1 for title matches, 2 for other matches, 3 for matches
+	// relying on typos and 0 without a text query.
+	Sort  int
+	Score float64
+	Tags  []string
+	// Snippets show where a text query matched the body.
+	Snippets []search.Snippet
+
+	match *IndexMatch
+}
+
+// SearchResult is a page of results together with the size and facets of
+// the whole result.
+type SearchResult struct {
+	Hits   []SearchHit
+	Total  int
+	Facets *repository.Facets
+	// Next is nil on the last page.
+	Next *SearchCursor
+	// Truncated is set when a regex search ran out of its size budget
+	// before reading every file.
+	Truncated bool
+}
+
+// Search returns a page of results. It gives up with the error of ctx when
+// it is done or the search timeout passes.
+func (s *SearchService) Search(ctx context.Context, req SearchRequest) (*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// 1. 取得這一頁的文章與總數
+	filter := req.Filter
+	filter.Sort, filter.Limit, filter.After = repository.ArticleSort{}, 0, nil
+	var result *SearchResult
+	var err error
+	switch {
+	case req.Regex != nil:
+		result, err = s.regexPage(ctx, req, &filter)
+	case req.Query != nil:
+		result, err = s.matchPage(ctx, req, &filter)
+	default:
+		result, err = s.listPage(req, filter, func(a *Article) SearchHit {
+			return newSearchHit(a, nil, false)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// 2. 整個結果 (不只這一頁) 的 facets
+	if result.Facets, err = s.repo.Facets(filter); err != nil {
+		return nil, err
+	}
+
+	// 3. 一次查出這一頁的 tags
+	ids := make([]uint, len(result.Hits))
+	for i, h := range result.Hits {
+		ids[i] = h.Article.ID
+	}
+	tags, err := s.repo.TagsOfArticles(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Hits {
+		result.Hits[i].Tags = tags[result.Hits[i].Article.ID]
+	}
+
+	// 4. 平行讀檔取 snippets
+	if err := s.snippets(ctx, result.Hits); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// matchPage matches the query against the articles of the filter. It
+// restricts filter to the matches for the facets.
+func (s *SearchService) matchPage(ctx context.Context, req SearchRequest, filter *repository.ArticleFilter) (*SearchResult, error) {
+	// 1. 先用 filter 取出候選文章，再用 index 比對
+	candidates, err := s.repo.FindArticles(*filter)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.index.Query(req.Query, candidates, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ranked := len(search.TextClauses(req.Query)) > 0
+	var hits []SearchHit
+	for i := range candidates {
+		if match, ok := matches[candidates[i].ID]; ok {
+			hits = append(hits, newSearchHit(&candidates[i], match, ranked))
+		}
+	}
+	restrict(filter, hits)
+
+	// 2. 依 score 在記憶體排序，其他欄位交給資料庫
+	if req.Relevance {
+		return rankedPage(hits, req), nil
+	}
+	return s.listPage(req, *filter, func(a *Article) SearchHit {
+		return newSearchHit(a, matches[a.ID], ranked)
+	})
+}
+
+// regexPage scans the files of the articles of the filter with the
+// pattern. It restricts filter to the matches for the facets.
+func (s *SearchService) regexPage(ctx context.Context, req SearchRequest, filter *repository.ArticleFilter) (*SearchResult, error) {
+	candidates, err := s.repo.FindArticles(*filter)
+	if err != nil {
+		return nil, err
+	}
+	hits, truncated, err := s.regexHits(ctx, req.Regex, candidates)
+	if err != nil {
+		return nil, err
+	}
+	restrict(filter, hits)
+
+	var result *SearchResult
+	if req.Relevance {
+		result = rankedPage(hits, req)
+	} else {
+		byID := make(map[uint]SearchHit, len(hits))
+		for _, h := range hits {
+			byID[h.Article.ID] = h
+		}
+		result, err = s.listPage(req, *filter, func(a *Article) SearchHit {
+			h := byID[a.ID]
+			h.Article = a
+			return h
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Truncated = truncated
+	return result, nil
+}
+
+// listPage lets the database sort and page the articles of the filter;
+// hit turns them into hits.
+func (s *SearchService) listPage(req SearchRequest, filter repository.ArticleFilter, hit func(*Article) SearchHit) (*SearchResult, error) {
+	total, err := s.repo.CountArticles(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter.Sort, filter.After = req.Sort, req.After
+	if req.Limit > 0 {
+		// 多拿一筆判斷是否還有下一頁
+		filter.Limit = req.Limit + 1
+	}
+	articles, err := s.repo.FindArticles(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Total: total}
+	if req.Limit > 0 && len(articles) > req.Limit {
+		articles = articles[:req.Limit]
+		result.Next = sortCursor(req.Sort, &articles[len(articles)-1])
+	}
+	result.Hits = make([]SearchHit, len(articles))
+	for i := range articles {
+		result.Hits[i] = hit(&articles[i])
+	}
+	return result, nil
+}
+
+// snippets fills in the snippets of the top hits of a text query.
+func (s *SearchService) snippets(ctx context.Context, hits []SearchHit) error {
+	if len(hits) > maxSnippetResults {
+		hits = hits[:maxSnippetResults]
+	}
+	return forEach(ctx, len(hits), func(i int) error {
+		h := &hits[i]
+		if h.match == nil {
+			return nil
+		}
+		snippets, err := s.index.Snippets(h.Article, h.match)
+		if err != nil {
+			return fmt.Errorf("snippets of %s: %w", h.Article.Path, err)
+		}
+		h.Snippets = snippets
+		return nil
+	})
+}
+
+// Helper functions
+
+// rankedPage orders hits by score, fuzzy matches after exact ones, and
+// pages them by offset. Scores are not in the database, so this is done in
+// memory.
+func rankedPage(hits []SearchHit, req SearchRequest) *SearchResult {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if fi, fj := hits[i].Sort == 3, hits[j].Sort == 3; fi != fj {
+			return fj
+		}
+		return hits[i].Score > hits[j].Score
+	})
+
+	result := &SearchResult{Total: len(hits)}
+	start := req.Offset
+	if start > len(hits) {
+		start = len(hits)
+	}
+	hits = hits[start:]
+	if req.Limit > 0 && len(hits) > req.Limit {
+		hits = hits[:req.Limit]
+		result.Next = &SearchCursor{Sort: SortRelevance, Offset: start + req.Limit}
+	}
+	result.Hits = hits
+	return result
+}
+
+// restrict narrows filter to the articles of hits.
+func restrict(filter *repository.ArticleFilter, hits []SearchHit) {
+	filter.IDs = make([]uint, len(hits))
+	for i, h := range hits {
+		filter.IDs[i] = h.Article.ID
+	}
+}
+
+// forEach calls fn for 0 to n-1 from a pool of searchWorkers goroutines.
+// The first error or the end of ctx stops it.
+func forEach(ctx context.Context, n int, fn func(i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := searchWorkers
+	if n < workers {
+		workers = n
+	}
+	jobs := make(chan int)
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	// set when the parent context ended, as cancel only runs on errors
+	return ctx.Err()
+}
+
+func newSearchHit(article *Article, match *IndexMatch, ranked bool) SearchHit {
+	if !ranked || match == nil {
+		return SearchHit{Article: article}
+	}
+	hit := SearchHit{Article: article, Score: match.Score + PopularityBonus(article), match: match}
+	switch {
+	case match.Fuzzy:
+		// 只靠錯字比對到的 Sort:3，排在最後
+		hit.Sort = 3
+	case match.InTitle:
+		// title match Sort:1
+		hit.Sort = 1
+	default:
+		// 剩下的是 content match Sort:2
+		hit.Sort = 2
+	}
+	return hit
+}
+
+func sortCursor(order repository.ArticleSort, last *Article) *SearchCursor {
+	cursor := order.Cursor(last)
+	return &SearchCursor{Sort: order.String(), ID: cursor.ID, Value: cursor.Value}
+}
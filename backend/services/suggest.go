@@ -0,0 +1,145 @@
+package services
+
+import (
+	"math"
+	"path"
+	"sync"
+	"unicode/utf8"
+
+	"pkms/backend/repository"
+	"pkms/backend/search"
+)
+
+// maxSuggestTerms is the number of the most frequent index terms offered
+// as completions; terms shorter than minSuggestTerm characters are left
+// out (CJK bigrams are words already).
+const (
+	maxSuggestTerms = 5000
+	minSuggestTerm  = 3
+)
+
+// suggestBoosts weigh the kinds of suggestions against each other, like
+// the field boosts of the index: a title beats a tag used as often.
+var suggestBoosts = map[string]float64{
+	search.SuggestTitle:  3,
+	search.SuggestTag:    2.5,
+	search.SuggestFolder: 2,
+	search.SuggestTerm:   1,
+}
+
+// SuggestService completes what is typed in the search bar from titles,
+// tags, folders and frequent index terms. It keeps them in memory and
+// builds them again from the database after articles change.
+type SuggestService struct {
+	repo repository.Repository
+
+	mu    sync.Mutex
+	index *search.PrefixIndex
+	stale bool
+}
+
+func NewSuggestService(repo repository.Repository) *SuggestService {
+	return &SuggestService{repo: repo}
+}
+
+// Invalidate tells that articles changed: the next Suggest rebuilds the
+// suggestions first.
+func (s *SuggestService) Invalidate() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.stale = true
+	s.mu.Unlock()
+}
+
+// Suggest returns at most max completions of prefix, most popular first,
+// restricted to kinds unless it is empty.
+func (s *SuggestService) Suggest(prefix string, kinds []string, max int) ([]search.Suggestion, error) {
+	index, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return index.Find(prefix, kinds, max), nil
+}
+
+// current returns the prefix index, rebuilding it when it is missing or
+// stale. Lookups run outside the lock: an index is never modified.
+func (s *SuggestService) current() (*search.PrefixIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index != nil && !s.stale {
+		return s.index, nil
+	}
+	// Invalidate waits for the build, so a change made meanwhile marks the
+	// new index stale again
+	index, err := s.build()
+	if err != nil {
+		return nil, err
+	}
+	s.index, s.stale = index, false
+	return index, nil
+}
+
+// build loads the suggestions in four queries.
+func (s *SuggestService) build() (*search.PrefixIndex, error) {
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	tags, err := articleTagNames(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := s.repo.FrequentTerms(maxSuggestTerms)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []search.Suggestion
+	// 1. titles: 引用次數與置頂決定熱門程度
+	tagCounts := map[string]int{}
+	folderCounts := map[string]int{}
+	for i := range articles {
+		a := &articles[i]
+		score := suggestScore(search.SuggestTitle, 1+a.RefCount)
+		if a.Pin {
+			// pinned articles first, like in search results
+			score++
+		}
+		items = append(items, search.Suggestion{Text: a.Title, Kind: search.SuggestTitle, ArticleID: a.ID, Score: score})
+		for _, tag := range tags[a.ID] {
+			tagCounts[tag]++
+		}
+		for dir := path.Dir(a.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			folderCounts[dir]++
+		}
+	}
+	// 2. tags 與 folders: 文章數
+	for tag, n := range tagCounts {
+		items = append(items, search.Suggestion{Text: tag, Kind: search.SuggestTag, Count: n, Score: suggestScore(search.SuggestTag, n)})
+	}
+	for dir, n := range folderCounts {
+		items = append(items, search.Suggestion{Text: dir, Kind: search.SuggestFolder, Count: n, Score: suggestScore(search.SuggestFolder, n)})
+	}
+	// 3. index terms: 出現的文章數
+	for _, t := range terms {
+		min := minSuggestTerm
+		if r, _ := utf8.DecodeRuneInString(t.Value); search.IsCJK(r) {
+			min = 2
+		}
+		if utf8.RuneCountInString(t.Value) < min {
+			continue
+		}
+		items = append(items, search.Suggestion{Text: t.Value, Kind: search.SuggestTerm, Count: t.Count, Score: suggestScore(search.SuggestTerm, t.Count)})
+	}
+	return search.NewPrefixIndex(items), nil
+}
+
+// Helper functions
+
+// suggestScore grows with the log of the number of articles behind a
+// suggestion, weighted by its kind.
+func suggestScore(kind string, count int) float64 {
+	return math.Round(suggestBoosts[kind]*math.Log1p(float64(count))*1000) / 1000
+}
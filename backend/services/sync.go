@@ -0,0 +1,653 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pkms/backend/config"
+	"pkms/backend/frontmatter"
+	"pkms/backend/repository"
+)
+
+type SyncAction string
+
+const (
+	SyncInsert SyncAction = "insert"
+	SyncUpdate SyncAction = "update"
+	SyncDelete SyncAction = "delete"
+	SyncRename SyncAction = "rename"
+)
+
+// SyncChange is one step needed to bring the database in line with the
+// articles directory.
+type SyncChange struct {
+	Action SyncAction `json:"action"`
+	Path   string     `json:"path"`
+	// OldPath is the previous location of a renamed article.
+	OldPath string `json:"old_path,omitempty"`
+	// ID is the article row for updates, renames and deletes.
+	ID uint `json:"id,omitempty"`
+	// Changes lists the drifted fields of an update ("title", "tags", ...).
+	Changes []FieldChange `json:"changes,omitempty"`
+	// Article, Tags and Properties are the values to store for inserts and
+	// updates.
+	Article    *Article               `json:"article,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// SyncError records a file that could not be planned or applied.
+type SyncError struct {
+	Path string `json:"path"`
+	Err  string `json:"error"`
+}
+
+// SyncPlan is the result of comparing the articles directory with the
+// database. Nothing is written until it is applied.
+type SyncPlan struct {
+	Changes []SyncChange `json:"changes"`
+	Errors  []SyncError  `json:"errors,omitempty"`
+	// Unchanged counts tracked files whose frontmatter matches the DB.
+	Unchanged int `json:"unchanged"`
+}
+
+// SyncService reconciles the markdown files under cfg.SearchPath into the
+// articles, tags and article_tags tables.
+type SyncService struct {
+	repo    repository.Repository
+	root    string
+	suggest *SuggestService
+}
+
+func NewSyncService(repo repository.Repository, cfg *config.Config) *SyncService {
+	return &SyncService{repo: repo, root: cfg.SearchPath}
+}
+
+// SetSuggestService makes the service refresh the search suggestions after
+// applying changes.
+func (s *SyncService) SetSuggestService(suggest *SuggestService) {
+	s.suggest = suggest
+}
+
+// fileArticle holds what an article file says about itself. Optional
+// frontmatter keys stay nil when they are missing so they never overwrite
+// the database.
+type fileArticle struct {
+	title      string
+	hasTitle   bool
+	typ        *string
+	tags       []string
+	hasTags    bool
+	createDate *frontmatterDate
+	editDate   *frontmatterDate
+	// properties are the custom frontmatter keys; the file always wins.
+	properties map[string]interface{}
+	checksum   string
+	modTime    time.Time
+}
+
+// frontmatterDate keeps the layout the date was written in, so "2024-03-14"
+// is compared by day only. Dates without a zone are local time, like the
+// date filters of search; t holds them in UTC.
+type frontmatterDate struct {
+	t      time.Time
+	layout string
+}
+
+var frontmatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Plan compares every markdown file with the database.
+func (s *SyncService) Plan() (*SyncPlan, error) {
+	files, err := s.markdownFiles("")
+	if err != nil {
+		return nil, err
+	}
+	return s.plan(files, nil, false)
+}
+
+// PlanPaths compares only the given paths (files or folders, relative to
+// the root) with the database. A path that no longer exists removes the
+// rows of the file or of every article below the folder. With touch set,
+// files without an edit_date in their frontmatter get their modification
+// time as edit_date, since the caller knows they were just edited.
+func (s *SyncService) PlanPaths(paths []string, touch bool) (*SyncPlan, error) {
+	var files, gone []string
+	seen := map[string]struct{}{}
+	for _, rel := range paths {
+		rel = cleanRel(rel)
+		info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(rel)))
+		switch {
+		case os.IsNotExist(err):
+			gone = append(gone, rel)
+		case err != nil:
+			return nil, err
+		case info.IsDir():
+			under, err := s.markdownFiles(rel)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range under {
+				if _, ok := seen[f]; !ok {
+					seen[f] = struct{}{}
+					files = append(files, f)
+				}
+			}
+		case isMarkdown(rel):
+			if _, ok := seen[rel]; !ok {
+				seen[rel] = struct{}{}
+				files = append(files, rel)
+			}
+		}
+	}
+	return s.plan(files, gone, touch)
+}
+
+// plan builds the changes for files. When gone is nil every tracked row is
+// checked for a missing file, otherwise only rows at or below gone paths.
+func (s *SyncService) plan(files, gone []string, touch bool) (*SyncPlan, error) {
+	articles, err := s.repo.FindArticles(repository.ArticleFilter{})
+	if err != nil {
+		return nil, err
+	}
+	state, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	onDisk := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		onDisk[f] = struct{}{}
+	}
+
+	plan := &SyncPlan{Changes: []SyncChange{}}
+	byPath := make(map[string]*Article, len(articles))
+	var deletes []SyncChange
+	for i := range articles {
+		a := &articles[i]
+		byPath[a.Path] = a
+		// 1. DB 有但檔案不存在 -> delete
+		if gone != nil && !underAny(a.Path, gone) {
+			continue
+		}
+		if _, ok := onDisk[a.Path]; ok {
+			continue
+		}
+		if gone != nil || !s.exists(a.Path) {
+			deletes = append(deletes, SyncChange{Action: SyncDelete, Path: a.Path, ID: a.ID, Article: a})
+		}
+	}
+
+	// 2. 檔案 -> insert / update
+	var inserts []SyncChange
+	for _, rel := range files {
+		change, err := s.planFile(rel, byPath[rel], state, touch)
+		if err != nil {
+			plan.Errors = append(plan.Errors, SyncError{Path: rel, Err: err.Error()})
+			continue
+		}
+		switch {
+		case change == nil:
+			plan.Unchanged++
+		case change.Action == SyncInsert:
+			inserts = append(inserts, *change)
+		default:
+			plan.Changes = append(plan.Changes, *change)
+		}
+	}
+
+	// 3. 同一篇文章被搬走 -> rename
+	plan.Changes = append(plan.Changes, pairRenames(deletes, inserts)...)
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool { return plan.Changes[i].Path < plan.Changes[j].Path })
+	return plan, nil
+}
+
+// pairRenames turns a delete and an insert into a rename when the new file
+// is clearly the old article: same file name, or else same title, and the
+// match is unique on both sides. Renames keep the row id, pin and ref_count.
+func pairRenames(deletes, inserts []SyncChange) []SyncChange {
+	var changes []SyncChange
+	used := make([]bool, len(inserts))
+	for _, del := range deletes {
+		match := uniqueMatch(inserts, used, func(ins SyncChange) bool {
+			return path.Base(ins.Path) == path.Base(del.Path)
+		})
+		if match < 0 {
+			match = uniqueMatch(inserts, used, func(ins SyncChange) bool {
+				return ins.Article.Title == del.Article.Title
+			})
+		}
+		if match < 0 {
+			del.Article = nil
+			changes = append(changes, del)
+			continue
+		}
+
+		ins := inserts[match]
+		used[match] = true
+		updated := *del.Article
+		updated.Path = ins.Path
+		updated.Title = ins.Article.Title
+		updated.Type = ins.Article.Type
+		updated.EditDate = ins.Article.EditDate
+		fieldChanges := []FieldChange{{"path", del.Path, ins.Path}}
+		if updated.Title != del.Article.Title {
+			fieldChanges = append(fieldChanges, FieldChange{"title", del.Article.Title, updated.Title})
+		}
+		changes = append(changes, SyncChange{
+			Action:     SyncRename,
+			Path:       ins.Path,
+			OldPath:    del.Path,
+			ID:         del.ID,
+			Changes:    fieldChanges,
+			Article:    &updated,
+			Tags:       ins.Tags,
+			Properties: ins.Properties,
+		})
+	}
+	for i, ins := range inserts {
+		if !used[i] {
+			changes = append(changes, ins)
+		}
+	}
+	return changes
+}
+
+func uniqueMatch(inserts []SyncChange, used []bool, match func(SyncChange) bool) int {
+	found := -1
+	for i, ins := range inserts {
+		if used[i] || !match(ins) {
+			continue
+		}
+		if found >= 0 {
+			return -1
+		}
+		found = i
+	}
+	return found
+}
+
+// Apply executes every change of the plan, each in its own transaction, and
+// returns the changes that failed.
+func (s *SyncService) Apply(plan *SyncPlan) []SyncError {
+	var failed []SyncError
+	for _, change := range plan.Changes {
+		if err := s.apply(change); err != nil {
+			failed = append(failed, SyncError{Path: change.Path, Err: err.Error()})
+		}
+	}
+	if len(plan.Changes) > len(failed) {
+		s.suggest.Invalidate()
+	}
+	return failed
+}
+
+func (s *SyncService) planFile(rel string, current *Article, state *syncState, touch bool) (*SyncChange, error) {
+	file, err := s.readArticleFile(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不在 DB -> insert
+	if current == nil {
+		article := &Article{
+			Title:      file.title,
+			Path:       rel,
+			Type:       "markdown",
+			CreateDate: file.modTime,
+			EditDate:   file.modTime,
+		}
+		if file.typ != nil {
+			article.Type = *file.typ
+		}
+		if file.createDate != nil {
+			article.CreateDate = file.createDate.t
+		}
+		if file.editDate != nil {
+			article.EditDate = file.editDate.t
+		}
+		return &SyncChange{Action: SyncInsert, Path: rel, Article: article, Tags: file.tags, Properties: file.properties}, nil
+	}
+
+	// 已在 DB -> 比對 frontmatter
+	updated := *current
+	tags := state.tags[current.ID]
+	var changes []FieldChange
+	if file.hasTitle && file.title != current.Title {
+		changes = append(changes, FieldChange{"title", current.Title, file.title})
+		updated.Title = file.title
+	}
+	if file.typ != nil && *file.typ != current.Type {
+		changes = append(changes, FieldChange{"type", current.Type, *file.typ})
+		updated.Type = *file.typ
+	}
+	if file.hasTags && !sameTags(tags, file.tags) {
+		changes = append(changes, FieldChange{"tags", strings.Join(tags, ", "), strings.Join(file.tags, ", ")})
+		tags = file.tags
+	}
+	if d := file.createDate; d != nil && !d.equal(current.CreateDate) {
+		changes = append(changes, FieldChange{"create_date", current.CreateDate.Local().Format(d.layout), d.t.Local().Format(d.layout)})
+		updated.CreateDate = d.t
+	}
+	if d := file.editDate; d != nil && !d.equal(current.EditDate) {
+		changes = append(changes, FieldChange{"edit_date", current.EditDate.Local().Format(d.layout), d.t.Local().Format(d.layout)})
+		updated.EditDate = d.t
+	} else if d == nil && touch && !file.modTime.Truncate(time.Second).Equal(current.EditDate.Truncate(time.Second)) {
+		const layout = "2006-01-02 15:04:05"
+		changes = append(changes, FieldChange{"edit_date", current.EditDate.Local().Format(layout), file.modTime.Local().Format(layout)})
+		updated.EditDate = file.modTime
+	}
+	changes = append(changes, propertyChanges(state.properties[current.ID], file.properties)...)
+	if indexed := state.checksums[current.ID]; indexed != file.checksum {
+		changes = append(changes, FieldChange{"content", shortChecksum(indexed), shortChecksum(file.checksum)})
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return &SyncChange{Action: SyncUpdate, Path: rel, ID: current.ID, Changes: changes, Article: &updated, Tags: tags, Properties: file.properties}, nil
+}
+
+func (s *SyncService) apply(change SyncChange) error {
+	tx, err := s.repo.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch change.Action {
+	case SyncInsert:
+		id, err := tx.InsertArticle(change.Article)
+		if err != nil {
+			return err
+		}
+		if err := s.indexFile(tx, id, change.Article, change.Tags); err != nil {
+			return err
+		}
+		if err := tx.SetArticleTags(id, change.Tags); err != nil {
+			return err
+		}
+		if err := tx.SetArticleProperties(id, change.Properties); err != nil {
+			return err
+		}
+	case SyncUpdate, SyncRename:
+		if err := tx.UpdateArticle(change.Article); err != nil {
+			return err
+		}
+		if err := tx.SetArticleTags(change.ID, change.Tags); err != nil {
+			return err
+		}
+		if err := tx.SetArticleProperties(change.ID, change.Properties); err != nil {
+			return err
+		}
+		if err := s.indexFile(tx, change.ID, change.Article, change.Tags); err != nil {
+			return err
+		}
+	case SyncDelete:
+		if err := tx.DeleteArticle(change.ID); err != nil && err != repository.ErrNotFound {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown sync action %q", change.Action)
+	}
+	return tx.Commit()
+}
+
+// indexFile reads the article file again so the index matches what is on
+// disk when the change is applied.
+func (s *SyncService) indexFile(tx repository.Tx, id uint, article *Article, tags []string) error {
+	content, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(article.Path)))
+	if err != nil {
+		return err
+	}
+	return indexArticle(tx, id, article.Title, tags, content)
+}
+
+// syncState is what the database knows about the articles besides their
+// rows, keyed by article id.
+type syncState struct {
+	tags       map[uint][]string
+	properties map[uint]map[string]interface{}
+	// checksums of the files the search index was built from
+	checksums map[uint]string
+}
+
+func (s *SyncService) loadState() (*syncState, error) {
+	tags, err := articleTagNames(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := s.propertiesByArticle()
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := s.repo.IndexChecksums()
+	if err != nil {
+		return nil, err
+	}
+	return &syncState{tags: tags, properties: properties, checksums: checksums}, nil
+}
+
+// articleTagNames loads every tag link with a constant number of queries.
+func articleTagNames(repo repository.Repository) (map[uint][]string, error) {
+	tags, err := repo.FindTags("")
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(tags))
+	for _, t := range tags {
+		names[t.ID] = t.Name
+	}
+	links, err := repo.ArticleTagLinks()
+	if err != nil {
+		return nil, err
+	}
+	byArticle := map[uint][]string{}
+	for _, l := range links {
+		byArticle[l.ArticleID] = append(byArticle[l.ArticleID], names[l.TagID])
+	}
+	return byArticle, nil
+}
+
+// propertiesByArticle loads every custom property in one query.
+func (s *SyncService) propertiesByArticle() (map[uint]map[string]interface{}, error) {
+	rows, err := s.repo.ArticlePropertyRows()
+	if err != nil {
+		return nil, err
+	}
+	byArticle := map[uint]map[string]interface{}{}
+	for _, row := range rows {
+		var value interface{}
+		if err := json.Unmarshal(row.Value, &value); err != nil {
+			return nil, fmt.Errorf("property %s of article %d: %w", row.Name, row.ArticleID, err)
+		}
+		if byArticle[row.ArticleID] == nil {
+			byArticle[row.ArticleID] = map[string]interface{}{}
+		}
+		byArticle[row.ArticleID][row.Name] = value
+	}
+	return byArticle, nil
+}
+
+// markdownFiles lists the .md files below dir (relative to the root, ""
+// for everything) as slash separated relative paths, skipping hidden files
+// and folders.
+func (s *SyncService) markdownFiles(dir string) ([]string, error) {
+	var files []string
+	start := filepath.Join(s.root, filepath.FromSlash(dir))
+	err := filepath.Walk(start, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != start && isHidden(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !isMarkdown(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+func (s *SyncService) exists(rel string) bool {
+	_, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+func (s *SyncService) readArticleFile(rel string) (*fileArticle, error) {
+	fullPath := filepath.Join(s.root, filepath.FromSlash(rel))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := frontmatter.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	title, _, err := doc.String("title")
+	if err != nil {
+		return nil, err
+	}
+	file := &fileArticle{
+		title:    title,
+		hasTitle: title != "",
+		checksum: checksum(content),
+		modTime:  info.ModTime(),
+	}
+	if typ, ok, err := doc.String("type"); err != nil {
+		return nil, err
+	} else if ok {
+		file.typ = &typ
+	}
+	if file.title == "" {
+		file.title = firstHeading(doc.Body)
+	}
+	if file.title == "" {
+		file.title = strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	}
+	if file.tags, file.hasTags, err = doc.StringList("tags"); err != nil {
+		return nil, err
+	}
+	if file.properties, err = customProperties(doc); err != nil {
+		return nil, err
+	}
+	createDate, _, err := doc.String("create_date")
+	if err != nil {
+		return nil, err
+	}
+	editDate, _, err := doc.String("edit_date")
+	if err != nil {
+		return nil, err
+	}
+	if file.createDate, err = parseFrontmatterDate(createDate); err != nil {
+		return nil, fmt.Errorf("create_date: %w", err)
+	}
+	if file.editDate, err = parseFrontmatterDate(editDate); err != nil {
+		return nil, fmt.Errorf("edit_date: %w", err)
+	}
+	return file, nil
+}
+
+// Helper functions
+
+func isMarkdown(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".md")
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// underAny reports whether p equals one of dirs or lies below it.
+func underAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if p == dir || dir == "" || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func firstHeading(body string) string {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(line[2:])
+		}
+	}
+	return ""
+}
+
+func parseFrontmatterDate(value string) (*frontmatterDate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range frontmatterDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &frontmatterDate{t: t.UTC(), layout: layout}, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized date %q", value)
+}
+
+func (d *frontmatterDate) equal(t time.Time) bool {
+	return t.Local().Format(d.layout) == d.t.Local().Format(d.layout)
+}
+
+func shortChecksum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, t := range a {
+		count[t]++
+	}
+	for _, t := range b {
+		count[t]--
+		if count[t] < 0 {
+			return false
+		}
+	}
+	return true
+}
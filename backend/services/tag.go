@@ -1,38 +1,12 @@
 package services
 
 import (
-	"database/sql"
-	"strings"
+	"pkms/backend/models"
+	"pkms/backend/repository"
 )
 
-type Tag struct {
-	ID   uint   `json:"id"`
-	Name string `json:"name"`
-}
-
-func GetTags(db *sql.DB, query string) ([]Tag, error) {
-	var (
-		rows *sql.Rows
-		err  error
-	)
-	if query != "" {
-		like := "%" + strings.ToLower(query) + "%"
-		rows, err = db.Query("SELECT id, name FROM tags WHERE LOWER(name) LIKE ?", like)
-	} else {
-		rows, err = db.Query("SELECT id, name FROM tags")
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+type Tag = models.Tag
 
-	var tags []Tag
-	for rows.Next() {
-		var tag Tag
-		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
-			return nil, err
-		}
-		tags = append(tags, tag)
-	}
-	return tags, nil
+func GetTags(repo repository.Repository, query string) ([]Tag, error) {
+	return repo.FindTags(query)
 }
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pkms/backend/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	WatchAuto = "auto" // inotify, polling when it is not available
+	WatchPoll = "poll"
+	WatchOff  = "off"
+
+	watchDebounce = 500 * time.Millisecond
+)
+
+// Watcher keeps the database in sync with edits made outside of the API.
+// Events are collected per path and synced in one batch once the tree has
+// been quiet for watchDebounce, so a rename (delete + create) is seen as a
+// single move and editors that write a file in several steps cause one
+// update.
+type Watcher struct {
+	syncer   *SyncService
+	root     string
+	mode     string
+	interval time.Duration
+
+	mu         sync.Mutex
+	pending    map[string]struct{}
+	timer      *time.Timer
+	suppressed map[string]time.Time
+	// syncing serializes flushes started by consecutive timers.
+	syncing sync.Mutex
+
+	fsw  *fsnotify.Watcher
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWatcher(syncer *SyncService, cfg *config.Config) *Watcher {
+	return &Watcher{
+		syncer:     syncer,
+		root:       cfg.SearchPath,
+		mode:       cfg.WatchMode,
+		interval:   cfg.WatchInterval,
+		pending:    map[string]struct{}{},
+		suppressed: map[string]time.Time{},
+		done:       make(chan struct{}),
+	}
+}
+
+// Start begins watching the articles root in the background.
+func (w *Watcher) Start() error {
+	if w.mode != WatchPoll {
+		err := w.startNotify()
+		if err == nil {
+			return nil
+		}
+		if w.mode != WatchAuto {
+			return err
+		}
+		log.Printf("watcher: inotify unavailable (%v), polling every %s", err, w.interval)
+	}
+
+	snapshot, err := w.snapshot()
+	if err != nil {
+		return err
+	}
+	w.wg.Add(1)
+	go w.poll(snapshot)
+	return nil
+}
+
+// Close stops the watcher. Pending events are dropped.
+func (w *Watcher) Close() error {
+	close(w.done)
+	var err error
+	if w.fsw != nil {
+		err = w.fsw.Close()
+	}
+	w.wg.Wait()
+
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return err
+}
+
+// Suppress ignores events for rel (relative to the root) for a short while.
+// The services call it before writing article files themselves, since they
+// already keep the database up to date.
+func (w *Watcher) Suppress(rel string) {
+	if w == nil {
+		return
+	}
+	window := 2 * w.interval
+	if window < 2*time.Second {
+		window = 2 * time.Second
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.suppressed[cleanRel(rel)] = time.Now().Add(window + watchDebounce)
+}
+
+func (w *Watcher) startNotify() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.addDirs(fsw, w.root); err != nil {
+		fsw.Close()
+		return err
+	}
+	w.fsw = fsw
+	w.wg.Add(1)
+	go w.notifyLoop()
+	return nil
+}
+
+// addDirs watches dir and every non-hidden folder below it; inotify is not
+// recursive.
+func (w *Watcher) addDirs(fsw *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p != w.root {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != w.root && isHidden(info.Name()) {
+			return filepath.SkipDir
+		}
+		return fsw.Add(p)
+	})
+}
+
+func (w *Watcher) notifyLoop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watcher: %v", err)
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addDirs(w.fsw, event.Name); err != nil {
+						log.Printf("watcher: %v", err)
+					}
+				}
+			}
+			w.queue(event.Name)
+		}
+	}
+}
+
+// fileState is what the polling watcher compares between two scans.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (w *Watcher) poll(prev map[string]fileState) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			next, err := w.snapshot()
+			if err != nil {
+				log.Printf("watcher: %v", err)
+				continue
+			}
+			for p, state := range next {
+				if old, ok := prev[p]; !ok || old != state {
+					w.queue(p)
+				}
+			}
+			for p := range prev {
+				if _, ok := next[p]; !ok {
+					w.queue(p)
+				}
+			}
+			prev = next
+		}
+	}
+}
+
+// snapshot records every markdown file under the root.
+func (w *Watcher) snapshot() (map[string]fileState, error) {
+	files := map[string]fileState{}
+	err := filepath.Walk(w.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if p != w.root && isHidden(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && isMarkdown(info.Name()) {
+			files[p] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// queue records an absolute path and (re)starts the debounce timer.
+func (w *Watcher) queue(p string) {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+	rel = cleanRel(rel)
+	for _, part := range strings.Split(rel, "/") {
+		if isHidden(part) {
+			return
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[rel] = struct{}{}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(watchDebounce, w.flush)
+	} else {
+		w.timer.Reset(watchDebounce)
+	}
+}
+
+// flush syncs the queued paths that are not suppressed.
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	var paths []string
+	for rel := range w.pending {
+		if !w.isSuppressed(rel) {
+			paths = append(paths, rel)
+		}
+	}
+	for rel, until := range w.suppressed {
+		if time.Now().After(until) {
+			delete(w.suppressed, rel)
+		}
+	}
+	w.pending = map[string]struct{}{}
+	w.timer = nil
+	w.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	w.syncing.Lock()
+	defer w.syncing.Unlock()
+	plan, err := w.syncer.PlanPaths(paths, true)
+	if err != nil {
+		log.Printf("watcher: %v", err)
+		return
+	}
+	// a new folder is synced as a whole, skip the files written by the API
+	w.mu.Lock()
+	changes := plan.Changes[:0]
+	for _, c := range plan.Changes {
+		if !w.isSuppressed(c.Path) && (c.OldPath == "" || !w.isSuppressed(c.OldPath)) {
+			changes = append(changes, c)
+		}
+	}
+	plan.Changes = changes
+	w.mu.Unlock()
+	for _, e := range plan.Errors {
+		log.Printf("watcher: %s: %s", e.Path, e.Err)
+	}
+	for _, c := range plan.Changes {
+		if c.OldPath != "" {
+			log.Printf("watcher: %s %s -> %s", c.Action, c.OldPath, c.Path)
+		} else {
+			log.Printf("watcher: %s %s", c.Action, c.Path)
+		}
+	}
+	for _, e := range w.syncer.Apply(plan) {
+		log.Printf("watcher: %s: %s", e.Path, e.Err)
+	}
+}
+
+// isSuppressed must be called with w.mu held.
+func (w *Watcher) isSuppressed(rel string) bool {
+	until, ok := w.suppressed[rel]
+	return ok && time.Now().Before(until)
+}
+
+func cleanRel(rel string) string {
+	return strings.Trim(filepath.ToSlash(filepath.Clean(rel)), "/")
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -44,3 +46,105 @@ func ValidateMarkdownPath(path string) bool {
 
 	return true
 }
+
+// WriteFileAtomic writes data to a temporary file next to path, flushes it
+// to disk and renames it over path, so a crash or a full disk leaves either
+// the old or the new content, never a truncated file. An existing file
+// keeps its permissions. Once it returns an error path is unchanged.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	// hidden and without the .md suffix, so the watcher ignores it
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // gone after the rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// the new content is in place; a folder that fails to flush only makes
+	// the rename less durable, so the write still succeeded
+	if err := syncDir(dir); err != nil {
+		log.Printf("failed to sync %s: %v", dir, err)
+	}
+	return nil
+}
+
+// RemoveEmptyParents deletes dir and then its parents while they are empty,
+// stopping at root, which is kept.
+func RemoveEmptyParents(root, dir string) error {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return err
+		}
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveEmptyDirs deletes every empty directory below root (root itself is
+// kept), deepest first so that chains of empty folders disappear.
+func RemoveEmptyDirs(root string) error {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the entries of dir, making a rename in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}